@@ -0,0 +1,148 @@
+// skew.go
+// measures how far the host clock is from the server's, since a host with
+// broken NTP sends heartbeats that land hours off on the dashboard
+
+package clock
+
+import (
+	"fmt"
+	"go_monitor/logger"
+	"go_monitor/payload"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var log = logger.For("clock")
+
+// Environment variables configuring skew detection
+const (
+	ThresholdEnvVar = "MONKEY_CLOCK_SKEW_THRESHOLD" // seconds before a clock_skew event is sent, default 60
+	CorrectEnvVar   = "MONKEY_CLOCK_CORRECT"        // true adds HeartbeatCorrected, the heartbeat in server time
+)
+
+// DefaultThreshold is how far off the clock may be before it is reported
+const DefaultThreshold = 60 * time.Second
+
+// preciseValidity is how long a server_time measurement is preferred over the Date header
+const preciseValidity = 5 * time.Minute
+
+// Latest measurement, shared by everything that talks to the server
+var (
+	mutex    sync.Mutex
+	skew     time.Duration
+	measured time.Time
+	precise  time.Time // when server_time was last seen
+)
+
+// ObserveResponse measures the skew from a response's Date header.
+// Date only has second precision, so the server time is taken as half way through that second.
+// It is ignored while a server_time measurement from the last preciseValidity is held.
+func ObserveResponse(resp *http.Response, sent, received time.Time) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !precise.IsZero() && received.Sub(precise) < preciseValidity {
+		return
+	}
+	observe(date.Add(500*time.Millisecond), sent, received)
+}
+
+// ObserveServerTime measures the skew from a server timestamp, assuming the server
+// read its clock half way between the request being sent and the response arriving
+func ObserveServerTime(server, sent, received time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	observe(server, sent, received)
+	precise = received
+}
+
+// observe stores a measurement, the caller holds mutex
+func observe(server, sent, received time.Time) {
+	local := sent.Add(received.Sub(sent) / 2)
+	skew = server.Sub(local)
+	measured = received
+}
+
+// Skew returns how far the server clock is ahead of the host's, and false if it hasn't been measured yet
+func Skew() (time.Duration, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return skew, !measured.IsZero()
+}
+
+// SkewEvent is sent when the host clock goes further off than the threshold
+type SkewEvent struct {
+	SkewSeconds      float64   `json:"skew_seconds"`
+	ThresholdSeconds float64   `json:"threshold_seconds"`
+	HostTime         time.Time `json:"host_time"`
+	ServerTime       time.Time `json:"server_time"`
+}
+
+// Checker adds the skew to heartbeats and notices when it crosses the threshold
+type Checker struct {
+	threshold time.Duration
+	correct   bool
+	skewed    bool
+}
+
+// NewCheckerFromEnv creates a checker configured by MONKEY_CLOCK_*.
+// The checker is always usable, settings that fail to parse keep their defaults.
+func NewCheckerFromEnv() (*Checker, error) {
+	c := &Checker{threshold: DefaultThreshold}
+	if env := os.Getenv(ThresholdEnvVar); env != "" {
+		seconds, err := strconv.Atoi(env)
+		if err != nil || seconds < 1 {
+			return c, fmt.Errorf("invalid %s %q", ThresholdEnvVar, env)
+		}
+		c.threshold = time.Duration(seconds) * time.Second
+	}
+	if env := os.Getenv(CorrectEnvVar); env != "" {
+		correct, err := strconv.ParseBool(env)
+		if err != nil {
+			return c, fmt.Errorf("invalid %s %q", CorrectEnvVar, env)
+		}
+		c.correct = correct
+	}
+	return c, nil
+}
+
+// Apply sets the measured skew, and the corrected heartbeat if enabled, on m.
+// Returns an event the first time the skew goes over the threshold, nil otherwise.
+func (c *Checker) Apply(m *payload.Mesure) *SkewEvent {
+	current, ok := Skew()
+	if !ok {
+		return nil
+	}
+
+	seconds := current.Seconds()
+	m.ClockSkew = &seconds
+	hostTime := time.Unix(m.Heartbeat, 0)
+	if c.correct {
+		m.HeartbeatCorrected = hostTime.Add(current).Unix()
+	}
+
+	over := current > c.threshold || current < -c.threshold
+	if over == c.skewed {
+		return nil
+	}
+	c.skewed = over
+	if !over {
+		log.Info("Host clock back within threshold of the server", "skew_seconds", seconds)
+		return nil
+	}
+
+	log.Warn("Host clock is off from the server, check NTP", "skew_seconds", seconds, "threshold_seconds", c.threshold.Seconds())
+	return &SkewEvent{
+		SkewSeconds:      seconds,
+		ThresholdSeconds: c.threshold.Seconds(),
+		HostTime:         hostTime,
+		ServerTime:       hostTime.Add(current),
+	}
+}
@@ -0,0 +1,41 @@
+package clock
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestObserveResponseKeepsServerTime(t *testing.T) {
+	mutex.Lock()
+	skew, measured, precise = 0, time.Time{}, time.Time{}
+	mutex.Unlock()
+
+	start := time.Unix(1700000000, 0)
+	steps := []struct {
+		name       string
+		at         time.Duration // since the first request
+		serverTime bool          // server_time rather than the Date header
+		ahead      time.Duration // server clock ahead of the request being sent
+		want       time.Duration
+	}{
+		// the round trip is 200ms, Date is taken as half way through its second
+		{"Date header before any server_time", 0, false, 10 * time.Second, 10*time.Second + 400*time.Millisecond},
+		{"server_time", time.Minute, true, 3250 * time.Millisecond, 3150 * time.Millisecond},
+		{"Date header right after server_time", 2 * time.Minute, false, 10 * time.Second, 3150 * time.Millisecond},
+		{"Date header once server_time is stale", 7 * time.Minute, false, 5 * time.Second, 5*time.Second + 400*time.Millisecond},
+	}
+	for _, step := range steps {
+		sent := start.Add(step.at)
+		received := sent.Add(200 * time.Millisecond)
+		if step.serverTime {
+			ObserveServerTime(sent.Add(step.ahead), sent, received)
+		} else {
+			resp := &http.Response{Header: http.Header{"Date": {sent.Add(step.ahead).UTC().Format(http.TimeFormat)}}}
+			ObserveResponse(resp, sent, received)
+		}
+		if got, ok := Skew(); !ok || got != step.want {
+			t.Errorf("%s: skew = %v, %v, want %v", step.name, got, ok, step.want)
+		}
+	}
+}
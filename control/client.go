@@ -0,0 +1,41 @@
+// client.go
+// sends a single command to the running agent's control socket
+
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Send runs command on the agent listening at path
+func Send(path, command string) (Response, error) {
+	var resp Response
+
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return resp, fmt.Errorf("agent not reachable on %s: %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	jsonBytes, err := json.Marshal(Request{Command: command})
+	if err != nil {
+		return resp, err
+	}
+	if _, err := conn.Write(append(jsonBytes, '\n')); err != nil {
+		return resp, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return resp, fmt.Errorf("failed to read reply: %w", err)
+	}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return resp, fmt.Errorf("invalid reply: %w", err)
+	}
+	return resp, nil
+}
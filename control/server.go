@@ -0,0 +1,218 @@
+// server.go
+// Unix domain socket for controlling the running agent, used by `monitor-monkey-agent ctl`
+
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_monitor/logger"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var log = logger.For("control")
+
+// Environment variable overriding the socket path, "off" disables the socket
+const SocketEnvVar = "MONKEY_CONTROL_SOCKET"
+
+// DefaultSocket lives in the install directory, which only the service user can write to
+const DefaultSocket = "/opt/monitor-monkey/agent.sock"
+
+// Only the service user (and root) may connect
+const socketMode = 0600
+
+// How long a client has to send its request and read the reply
+const requestTimeout = 30 * time.Second
+
+// Request is a single command sent to the agent as one JSON line
+type Request struct {
+	Command string `json:"command"`
+}
+
+// Response is the agent's JSON line reply
+type Response struct {
+	OK      bool            `json:"ok"`
+	Message string          `json:"message,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Handler runs a command, returning a message for the user and an optional result to encode
+type Handler func() (message string, result interface{}, err error)
+
+// command is a registered handler with its help text
+type command struct {
+	help    string
+	handler Handler
+}
+
+// Server accepts control connections and runs the matching handler
+type Server struct {
+	path     string
+	mutex    sync.Mutex
+	commands map[string]command
+	listener net.Listener
+}
+
+// SocketPath returns the configured socket path, or "" if the socket is disabled
+func SocketPath() string {
+	path := os.Getenv(SocketEnvVar)
+	switch path {
+	case "":
+		return DefaultSocket
+	case "off":
+		return ""
+	}
+	return path
+}
+
+// NewServer creates a server for the socket at path with only the help command
+func NewServer(path string) *Server {
+	s := &Server{path: path, commands: make(map[string]command)}
+	s.Handle("help", "List the available commands", func() (string, interface{}, error) {
+		return s.help(), nil, nil
+	})
+	return s
+}
+
+// Handle registers a command, must be called before Start
+func (s *Server) Handle(name, help string, handler Handler) {
+	s.commands[name] = command{help: help, handler: handler}
+}
+
+// Start creates the socket, replacing one left behind by an agent that is no longer running
+func (s *Server) Start() error {
+	if err := removeStale(s.path); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.path, err)
+	}
+	if err := os.Chmod(s.path, socketMode); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict %s: %w", s.path, err)
+	}
+	s.listener = listener
+	log.Info("Listening for control commands", "socket", s.path)
+
+	go s.serve()
+	return nil
+}
+
+// Stop closes the socket, which also removes it
+func (s *Server) Stop() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Error("Failed to accept control connection", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle reads one request, runs it and writes the reply
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := checkPeer(conn); err != nil {
+		log.Warn("Rejected control connection", "err", err)
+		writeResponse(conn, Response{Error: "permission denied"})
+		return
+	}
+
+	var req Request
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err != nil {
+		writeResponse(conn, Response{Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	writeResponse(conn, s.run(req.Command))
+}
+
+// run executes a command, one at a time so commands don't race each other
+func (s *Server) run(name string) Response {
+	cmd, ok := s.commands[name]
+	if !ok {
+		return Response{Error: fmt.Sprintf("unknown command %q, try help", name)}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	log.Info("Running control command", "command", name)
+	message, result, err := cmd.handler()
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+
+	resp := Response{OK: true, Message: message}
+	if result != nil {
+		if resp.Result, err = json.Marshal(result); err != nil {
+			return Response{Error: "failed to encode result: " + err.Error()}
+		}
+	}
+	return resp
+}
+
+// help lists the commands in name order
+func (s *Server) help() string {
+	names := make([]string, 0, len(s.commands))
+	for name := range s.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var help string
+	for _, name := range names {
+		help += fmt.Sprintf("%-16s %s\n", name, s.commands[name].help)
+	}
+	return help
+}
+
+func writeResponse(conn net.Conn, resp Response) {
+	jsonBytes, _ := json.Marshal(resp)
+	conn.Write(append(jsonBytes, '\n'))
+}
+
+// removeStale deletes a socket file nobody is listening on any more
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another agent is already listening on %s", path)
+	}
+	return os.Remove(path)
+}
@@ -1,11 +1,10 @@
 package custom
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"go_monitor/logger"
+	"go_monitor/payload"
+	"go_monitor/schedule"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -14,6 +13,8 @@ import (
 	"time"
 )
 
+var log = logger.For("custom")
+
 // Default directory to look for .mm files
 const DefaultAlertsDir = "/opt/monitor-monkey/custom-events/"
 
@@ -36,16 +37,17 @@ type AlertDefinition struct {
 type AlertMonitor struct {
 	alertsDir     string
 	alerts        map[string]*AlertDefinition
-	client        *http.Client
-	baseURL       string
-	authHeader    string
+	publish       func(rec *payload.Record)
 	hostID        string
+	schedule      *schedule.Scheduler
 	stopChan      chan struct{}
 	mutex         sync.Mutex
+	started       bool // every alert has been sent once, by the first tick or a reload
 }
 
-// NewAlertMonitor creates a new alert monitor instance
-func NewAlertMonitor(client *http.Client, baseURL, authHeader, hostID string) *AlertMonitor {
+// NewAlertMonitor creates a new alert monitor instance, alerts are handed to publish.
+// sched spreads the checks out so hosts don't all send their alerts together.
+func NewAlertMonitor(publish func(rec *payload.Record), hostID string, sched *schedule.Scheduler) *AlertMonitor {
 	// Get alerts directory from environment variable or use default
 	alertsDir := os.Getenv(AlertsDirEnvVar)
 	if alertsDir == "" {
@@ -55,10 +57,9 @@ func NewAlertMonitor(client *http.Client, baseURL, authHeader, hostID string) *A
 	return &AlertMonitor{
 		alertsDir:  alertsDir,
 		alerts:     make(map[string]*AlertDefinition),
-		client:     client,
-		baseURL:    baseURL,
-		authHeader: authHeader,
+		publish:    publish,
 		hostID:     hostID,
+		schedule:   sched,
 		stopChan:   make(chan struct{}),
 		mutex:      sync.Mutex{},
 	}
@@ -66,21 +67,17 @@ func NewAlertMonitor(client *http.Client, baseURL, authHeader, hostID string) *A
 
 // Start begins monitoring and sending custom alerts
 func (am *AlertMonitor) Start() {
-	fmt.Println("Starting custom alerts monitor")
+	log.Info("Starting custom alerts monitor", "dir", am.alertsDir)
 	
 	// Create alerts directory if it doesn't exist
 	if err := os.MkdirAll(am.alertsDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating alerts directory: %v\n", err)
+		log.Error("Failed to create alerts directory", "dir", am.alertsDir, "err", err)
 	}
 	
 	// Load initial alerts
 	am.loadAlerts()
 	
-	// Send all alerts immediately on boot
-	fmt.Println("Sending all custom alerts immediately on startup")
-	am.sendAllAlerts()
-	
-	// Start the monitoring goroutine
+	// Start the monitoring goroutine, it sends all alerts once the host's splay is up
 	go am.monitorAlerts()
 }
 
@@ -91,15 +88,15 @@ func (am *AlertMonitor) sendAllAlerts() {
 	am.mutex.Unlock()
 	
 	if alertCount == 0 {
-		fmt.Println("No custom alerts found to send")
+		log.Info("No custom alerts found to send")
 		return
 	}
 	
-	fmt.Printf("Sending %d custom alerts...\n", alertCount)
+	log.Info("Sending custom alerts", "count", alertCount)
 	
 	am.mutex.Lock()
 	for _, alert := range am.alerts {
-		go am.sendAlert(alert)
+		am.sendAlert(alert)
 		alert.LastSent = time.Now() // Update the last sent time
 	}
 	am.mutex.Unlock()
@@ -110,14 +107,40 @@ func (am *AlertMonitor) Stop() {
 	close(am.stopChan)
 }
 
+// RunOnce loads the alerts and sends each of them once, without watching for changes
+func (am *AlertMonitor) RunOnce() {
+	am.loadAlerts()
+	am.sendAllAlerts()
+}
+
+// Reload rescans the alerts directory and sends any that are due now rather than
+// waiting for the next check, returning how many alerts are loaded
+func (am *AlertMonitor) Reload() int {
+	// checkAlerts sends every alert not sent yet, the first tick mustn't send them again
+	am.markStarted()
+	am.checkAlerts()
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	return len(am.alerts)
+}
+
+// markStarted records that every alert has been sent once, reporting whether that was already so
+func (am *AlertMonitor) markStarted() bool {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	started := am.started
+	am.started = true
+	return started
+}
+
 // loadAlerts scans the alerts directory and loads all .mm files
 func (am *AlertMonitor) loadAlerts() {
-	fmt.Printf("Loading alerts from %s\n", am.alertsDir)
+	log.Debug("Loading alerts", "dir", am.alertsDir)
 	
 	// Scan directory for .mm files
 	files, err := filepath.Glob(filepath.Join(am.alertsDir, "*.mm"))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error scanning alerts directory: %v\n", err)
+		log.Error("Failed to scan alerts directory", "dir", am.alertsDir, "err", err)
 		return
 	}
 	
@@ -135,7 +158,7 @@ func (am *AlertMonitor) loadAlerts() {
 	// Process each file
 	for _, file := range files {
 		if alert, err := am.parseAlertFile(file); err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing alert file %s: %v\n", file, err)
+			log.Error("Failed to parse alert file", "file", file, "err", err)
 		} else {
 			// Preserve the LastSent timestamp if this alert existed before
 			if lastSent, exists := lastSentTimes[file]; exists {
@@ -143,7 +166,7 @@ func (am *AlertMonitor) loadAlerts() {
 			}
 			
 			newAlerts[file] = alert
-			fmt.Printf("Loaded alert: %s, interval: %v\n", alert.Name, alert.Interval)
+			log.Debug("Loaded alert", "name", alert.Name, "interval", alert.Interval)
 		}
 	}
 	
@@ -210,7 +233,7 @@ func (am *AlertMonitor) parseAlertFile(path string) (*AlertDefinition, error) {
 			// Ensure minimum interval
 			if interval < MinAlertInterval {
 				interval = MinAlertInterval
-				fmt.Printf("Warning: Interval for %s is less than minimum, using %v instead\n", path, MinAlertInterval)
+				log.Warn("Alert interval is less than minimum, using minimum instead", "file", path, "minimum", MinAlertInterval)
 			}
 			alert.Interval = interval
 		case "data":
@@ -287,15 +310,21 @@ func parseInt(val string) (int, error) {
 	return result, err
 }
 
-// monitorAlerts periodically checks and sends alerts
+// monitorAlerts sends all alerts on the first tick, then periodically checks and sends alerts
 func (am *AlertMonitor) monitorAlerts() {
-	// Ticker for checking alerts (every minute)
-	ticker := time.NewTicker(MinAlertInterval)
+	// Ticker for checking alerts (every minute), the first tick comes after the host's splay
+	ticker := am.schedule.NewTicker("custom_alerts", MinAlertInterval)
 	defer ticker.Stop()
 	
 	for {
 		select {
 		case <-ticker.C:
+			if !am.markStarted() {
+				log.Info("Sending all custom alerts on startup")
+				am.loadAlerts()
+				am.sendAllAlerts()
+				continue
+			}
 			am.checkAlerts()
 		case <-am.stopChan:
 			return
@@ -316,52 +345,13 @@ func (am *AlertMonitor) checkAlerts() {
 	for _, alert := range am.alerts {
 		// Check if it's time to send this alert
 		if now.Sub(alert.LastSent) >= alert.Interval {
-			go am.sendAlert(alert)
+			am.sendAlert(alert)
 			alert.LastSent = now
 		}
 	}
 }
 
-// sendAlert sends an alert to the custom-events API
+// sendAlert publishes an alert to the output sinks
 func (am *AlertMonitor) sendAlert(alert *AlertDefinition) {
-	// Create event payload in the format expected by custom-events endpoint
-	eventPayload := map[string]interface{}{
-		"host_id": am.hostID,
-		"name": alert.Name,
-		"value": alert.Data,
-	}
-	
-	// Marshal the payload
-	jsonBytes, err := json.Marshal(eventPayload)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error marshaling alert data: %v\n", err)
-		return
-	}
-	
-	// Create and send the request to the custom-events endpoint
-	customEventsApi := am.baseURL + "/api/custom-events/"
-	req, err := http.NewRequest("POST", customEventsApi, bytes.NewBuffer(jsonBytes))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return
-	}
-	
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", am.authHeader)
-	
-	resp, err := am.client.Do(req)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending alert: %v\n", err)
-		return
-	}
-	defer resp.Body.Close()
-	
-	// Log success or failure
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		fmt.Printf("Successfully sent custom alert '%s' at %s\n", alert.Name, time.Now().Format(time.RFC3339))
-	} else {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Fprintf(os.Stderr, "Failed to send alert '%s'. Status: %d, Response: %s\n", 
-			alert.Name, resp.StatusCode, string(body))
-	}
+	am.publish(payload.NewCustomAlertRecord(am.hostID, alert.Name, alert.Data))
 }
@@ -0,0 +1,258 @@
+// connections.go
+// summarises the TCP connection table: connections per listening port and remote
+// peer, sockets per state, and listening services that look to be leaking CLOSE_WAIT
+
+package events
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables configuring the connections event
+const (
+	ConnectionsIntervalEnvVar = "MONKEY_CONNECTIONS_INTERVAL" // seconds between connections events, default 900, 0 disables them
+	ConnectionsTopEnvVar      = "MONKEY_CONNECTIONS_TOP"      // ports and peers listed, default 10
+	CloseWaitThresholdEnvVar  = "MONKEY_CLOSE_WAIT_THRESHOLD" // CLOSE_WAIT sockets on a port before it may be leaking, default 20
+)
+
+// TCP states as numbered in /proc/net/tcp
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+// ConnectionOptions configures the connections event
+type ConnectionOptions struct {
+	Interval           time.Duration
+	Top                int
+	CloseWaitThreshold int
+}
+
+// ConnectionOptionsFromEnv reads the MONKEY_CONNECTIONS_* variables.
+// The options are always usable, settings that fail to parse keep their defaults.
+func ConnectionOptionsFromEnv() (ConnectionOptions, error) {
+	opts := ConnectionOptions{Interval: 15 * time.Minute, Top: 10, CloseWaitThreshold: 20}
+	for _, setting := range []struct {
+		name  string
+		min   int
+		apply func(value int)
+	}{
+		{ConnectionsIntervalEnvVar, 0, func(v int) { opts.Interval = time.Duration(v) * time.Second }},
+		{ConnectionsTopEnvVar, 1, func(v int) { opts.Top = v }},
+		{CloseWaitThresholdEnvVar, 1, func(v int) { opts.CloseWaitThreshold = v }},
+	} {
+		env := os.Getenv(setting.name)
+		if env == "" {
+			continue
+		}
+		value, err := strconv.Atoi(env)
+		if err != nil || value < setting.min {
+			return opts, fmt.Errorf("invalid %s %q", setting.name, env)
+		}
+		setting.apply(value)
+	}
+	return opts, nil
+}
+
+// ConnectionSummary is sent as the connections event
+type ConnectionSummary struct {
+	Total          int               `json:"total"`
+	States         map[string]int    `json:"states"`
+	ByLocalPort    []PortConnections `json:"by_local_port"` // connections to listening ports, busiest first
+	TopPeers       []PeerConnections `json:"top_peers"`     // remote addresses with the most established connections
+	CloseWaitLeaks []PortConnections `json:"close_wait_leaks,omitempty"`
+}
+
+// PortConnections counts the connections to one listening port
+type PortConnections struct {
+	Port        int    `json:"port"`
+	Service     string `json:"service,omitempty"`
+	Established int    `json:"established"`
+	CloseWait   int    `json:"close_wait"`
+}
+
+// PeerConnections counts the established connections with one remote address
+type PeerConnections struct {
+	Address     string `json:"address"`
+	Established int    `json:"established"`
+}
+
+// connection is one row of /proc/net/tcp
+type connection struct {
+	localPort  int
+	remoteAddr string
+	remoteLoop bool
+	state      string
+}
+
+// ConnectionWatcher summarises the connection table, remembering CLOSE_WAIT counts
+// between summaries so only ports where they persist are flagged as leaking
+type ConnectionWatcher struct {
+	opts          ConnectionOptions
+	mutex         sync.Mutex
+	lastCloseWait map[int]int
+}
+
+// NewConnectionWatcher creates a watcher with opts
+func NewConnectionWatcher(opts ConnectionOptions) *ConnectionWatcher {
+	return &ConnectionWatcher{opts: opts}
+}
+
+// Summarise reads the IPv4 and IPv6 connection tables. A listening port is flagged as
+// leaking when it has at least the threshold of CLOSE_WAIT sockets in this summary and
+// the one before, and no fewer than before: the service isn't closing its side.
+func (w *ConnectionWatcher) Summarise() (ConnectionSummary, error) {
+	summary := ConnectionSummary{States: make(map[string]int)}
+
+	var conns []connection
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		c, err := readConnections(file)
+		if err != nil {
+			// IPv6 may be disabled, only the IPv4 table has to be there
+			if file == "/proc/net/tcp" {
+				return summary, err
+			}
+			continue
+		}
+		conns = append(conns, c...)
+	}
+
+	listening := make(map[int]bool)
+	for _, c := range conns {
+		if c.state == "LISTEN" {
+			listening[c.localPort] = true
+		}
+	}
+
+	ports := make(map[int]*PortConnections)
+	peers := make(map[string]int)
+	for _, c := range conns {
+		summary.Total++
+		summary.States[c.state]++
+		if c.state == "LISTEN" || c.remoteLoop {
+			continue
+		}
+
+		if listening[c.localPort] {
+			port, ok := ports[c.localPort]
+			if !ok {
+				port = &PortConnections{Port: c.localPort}
+				ports[c.localPort] = port
+			}
+			switch c.state {
+			case "ESTABLISHED":
+				port.Established++
+			case "CLOSE_WAIT":
+				port.CloseWait++
+			}
+		}
+		if c.state == "ESTABLISHED" {
+			peers[c.remoteAddr]++
+		}
+	}
+
+	tcpServices, _, err := loadServices()
+	if err != nil {
+		log.Warn("Could not load /etc/services", "err", err)
+	}
+	for _, port := range ports {
+		port.Service = tcpServices[port.Port]
+		summary.ByLocalPort = append(summary.ByLocalPort, *port)
+	}
+	sort.Slice(summary.ByLocalPort, func(i, j int) bool {
+		a, b := summary.ByLocalPort[i], summary.ByLocalPort[j]
+		if a.Established+a.CloseWait != b.Established+b.CloseWait {
+			return a.Established+a.CloseWait > b.Established+b.CloseWait
+		}
+		return a.Port < b.Port
+	})
+
+	for address, count := range peers {
+		summary.TopPeers = append(summary.TopPeers, PeerConnections{Address: address, Established: count})
+	}
+	sort.Slice(summary.TopPeers, func(i, j int) bool {
+		a, b := summary.TopPeers[i], summary.TopPeers[j]
+		if a.Established != b.Established {
+			return a.Established > b.Established
+		}
+		return a.Address < b.Address
+	})
+
+	w.mutex.Lock()
+	closeWait := make(map[int]int, len(ports))
+	for _, port := range summary.ByLocalPort {
+		closeWait[port.Port] = port.CloseWait
+		prev, seen := w.lastCloseWait[port.Port]
+		if seen && port.CloseWait >= w.opts.CloseWaitThreshold && prev >= w.opts.CloseWaitThreshold && port.CloseWait >= prev {
+			summary.CloseWaitLeaks = append(summary.CloseWaitLeaks, port)
+			log.Warn("Listening service may be leaking CLOSE_WAIT connections", "port", port.Port,
+				"service", port.Service, "close_wait", port.CloseWait, "previous", prev)
+		}
+	}
+	w.lastCloseWait = closeWait
+	w.mutex.Unlock()
+
+	if len(summary.ByLocalPort) > w.opts.Top {
+		summary.ByLocalPort = summary.ByLocalPort[:w.opts.Top]
+	}
+	if len(summary.TopPeers) > w.opts.Top {
+		summary.TopPeers = summary.TopPeers[:w.opts.Top]
+	}
+	return summary, nil
+}
+
+// readConnections parses every socket in a /proc/net/tcp file
+func readConnections(procFile string) ([]connection, error) {
+	content, err := os.ReadFile(procFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var conns []connection
+	lines := strings.Split(string(content), "\n")
+	for _, line := range lines[1:] { // Skip header line
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		_, localPort, err := parseLocalAddress(fields[1])
+		if err != nil {
+			continue
+		}
+		remoteIP, _, err := parseLocalAddress(fields[2])
+		if err != nil {
+			continue
+		}
+		state, ok := tcpStates[fields[3]]
+		if !ok {
+			state = "UNKNOWN"
+		}
+		// IPv4 peers on an IPv6 socket show up as ::ffff:a.b.c.d
+		if ip4 := remoteIP.To4(); ip4 != nil {
+			remoteIP = ip4
+		}
+		conns = append(conns, connection{
+			localPort:  localPort,
+			remoteAddr: remoteIP.String(),
+			remoteLoop: remoteIP.IsLoopback(),
+			state:      state,
+		})
+	}
+	return conns, nil
+}
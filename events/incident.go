@@ -0,0 +1,203 @@
+// incident.go
+// takes a snapshot of the top processes by CPU, memory and IO as soon as load,
+// memory or iowait cross a threshold, instead of waiting for the daily send
+
+package events
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Environment variables configuring incident snapshots, a threshold of 0 disables its trigger
+const (
+	IncidentLoadEnvVar     = "MONKEY_INCIDENT_LOAD_PER_CORE"  // 1 minute load average per core, default 2
+	IncidentMemoryEnvVar   = "MONKEY_INCIDENT_MEMORY_PERCENT" // memory in use, default 95
+	IncidentIOWaitEnvVar   = "MONKEY_INCIDENT_IOWAIT_PERCENT" // CPU time waiting on IO, default 40
+	IncidentCooldownEnvVar = "MONKEY_INCIDENT_COOLDOWN"       // seconds before a trigger that stays over fires again, default 600
+)
+
+// Metrics the incident triggers watch
+const (
+	IncidentLoadPerCore   = "load_per_core"
+	IncidentMemoryPercent = "memory_percent"
+	IncidentIOWaitPercent = "iowait_percent"
+)
+
+// IncidentEvent is the event type of an incident snapshot
+const IncidentEvent = "incident_processes"
+
+// maxIncidentCooldown caps the cooldown, which doubles each time a trigger fires
+// during the same incident
+const maxIncidentCooldown = 6 * time.Hour
+
+// IncidentOptions holds the trigger thresholds and the cooldown between snapshots
+type IncidentOptions struct {
+	Thresholds map[string]float64
+	Cooldown   time.Duration
+}
+
+// IncidentOptionsFromEnv reads the MONKEY_INCIDENT_* variables.
+// The options are always usable, settings that fail to parse keep their defaults.
+func IncidentOptionsFromEnv() (IncidentOptions, error) {
+	opts := IncidentOptions{
+		Thresholds: map[string]float64{IncidentLoadPerCore: 2, IncidentMemoryPercent: 95, IncidentIOWaitPercent: 40},
+		Cooldown:   10 * time.Minute,
+	}
+	for _, setting := range []struct {
+		name  string
+		apply func(value float64)
+	}{
+		{IncidentLoadEnvVar, func(v float64) { opts.Thresholds[IncidentLoadPerCore] = v }},
+		{IncidentMemoryEnvVar, func(v float64) { opts.Thresholds[IncidentMemoryPercent] = v }},
+		{IncidentIOWaitEnvVar, func(v float64) { opts.Thresholds[IncidentIOWaitPercent] = v }},
+		{IncidentCooldownEnvVar, func(v float64) { opts.Cooldown = time.Duration(v * float64(time.Second)) }},
+	} {
+		env := os.Getenv(setting.name)
+		if env == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(env, 64)
+		if err != nil || value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return opts, fmt.Errorf("invalid %s %q", setting.name, env)
+		}
+		setting.apply(value)
+	}
+	if opts.Cooldown < time.Minute {
+		opts.Cooldown = time.Minute
+	}
+	return opts, nil
+}
+
+// IncidentTrigger is a metric that crossed its threshold
+type IncidentTrigger struct {
+	Metric    string  `json:"metric"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+}
+
+// ProcessIOStat holds a process's storage IO for JSON output
+type ProcessIOStat struct {
+	PID              int32   `json:"pid"`
+	Name             string  `json:"name"`
+	Username         string  `json:"username"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+}
+
+// IncidentSnapshot is sent as the incident_processes event
+type IncidentSnapshot struct {
+	Triggers []IncidentTrigger `json:"triggers"`
+	CPU      []ProcessCPUStat  `json:"cpu"`
+	Mem      []ProcessMemStat  `json:"mem"`
+	IO       []ProcessIOStat   `json:"io"` // only processes whose IO the agent may read
+}
+
+// incidentState is a trigger that's over its threshold
+type incidentState struct {
+	cooldown time.Duration
+	next     time.Time
+}
+
+// IncidentWatcher decides when a snapshot is due. A trigger fires when its metric
+// crosses the threshold, then again each cooldown while it stays over, the cooldown
+// doubling each time, so a day long incident sends a handful of snapshots.
+type IncidentWatcher struct {
+	opts   IncidentOptions
+	mutex  sync.Mutex
+	active map[string]*incidentState
+}
+
+// NewIncidentWatcher creates a watcher with opts
+func NewIncidentWatcher(opts IncidentOptions) *IncidentWatcher {
+	return &IncidentWatcher{opts: opts, active: make(map[string]*incidentState)}
+}
+
+// Check compares the metrics with the thresholds and returns the triggers that fire,
+// a snapshot should be taken when there are any. Metrics not known this cycle are left out.
+func (w *IncidentWatcher) Check(metrics map[string]float64) []IncidentTrigger {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := time.Now()
+	var fired []IncidentTrigger
+	for _, metric := range []string{IncidentLoadPerCore, IncidentMemoryPercent, IncidentIOWaitPercent} {
+		threshold := w.opts.Thresholds[metric]
+		value, known := metrics[metric]
+		if threshold <= 0 || !known {
+			continue
+		}
+		state, over := w.active[metric]
+		if value < threshold {
+			if over {
+				log.Info("Incident over", "metric", metric, "value", value, "threshold", threshold)
+				delete(w.active, metric)
+			}
+			continue
+		}
+		if !over {
+			state = &incidentState{}
+			w.active[metric] = state
+		} else if now.Before(state.next) {
+			continue
+		}
+		state.cooldown = min(max(state.cooldown*2, w.opts.Cooldown), maxIncidentCooldown)
+		state.next = now.Add(state.cooldown)
+		fired = append(fired, IncidentTrigger{Metric: metric, Value: math.Round(value*100) / 100, Threshold: threshold})
+		log.Warn("Incident threshold crossed", "metric", metric, "value", value, "threshold", threshold,
+			"next_snapshot_after", state.cooldown)
+	}
+	return fired
+}
+
+// CaptureIncident samples every process's CPU and IO over a second and returns the
+// topN by CPU, by resident memory and by IO, with the triggers attached
+func CaptureIncident(triggers []IncidentTrigger, topN int) (IncidentSnapshot, error) {
+	snapshot := IncidentSnapshot{Triggers: triggers}
+	usage, _, err := (&processSampler{withIO: true}).sample()
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to sample processes: %w", err)
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].cpuPercent > usage[j].cpuPercent })
+	for _, u := range usage[:min(topN, len(usage))] {
+		snapshot.CPU = append(snapshot.CPU, ProcessCPUStat{
+			PID:        u.pid,
+			Name:       u.name,
+			Username:   processUser(u.pid),
+			CPUPercent: math.Round(u.cpuPercent*10) / 10,
+		})
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].rssKB > usage[j].rssKB })
+	for _, u := range usage[:min(topN, len(usage))] {
+		snapshot.Mem = append(snapshot.Mem, ProcessMemStat{
+			PID:      u.pid,
+			Name:     u.name,
+			Username: processUser(u.pid),
+			RSS_KB:   u.rssKB,
+		})
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].readRate+usage[i].writeRate > usage[j].readRate+usage[j].writeRate
+	})
+	for _, u := range usage {
+		if len(snapshot.IO) == topN || u.readRate+u.writeRate == 0 {
+			break
+		}
+		snapshot.IO = append(snapshot.IO, ProcessIOStat{
+			PID:              u.pid,
+			Name:             u.name,
+			Username:         processUser(u.pid),
+			ReadBytesPerSec:  math.Round(u.readRate),
+			WriteBytesPerSec: math.Round(u.writeRate),
+		})
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,164 @@
+// interfaces.go
+// watches the network interfaces for links dropping, renegotiating or changing address
+
+package events
+
+import (
+	"fmt"
+	"go_monitor/monitors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Environment variable setting how often, in seconds, interfaces are checked for changes, 0 turns it off
+const InterfaceCheckIntervalEnvVar = "MONKEY_INTERFACE_CHECK_INTERVAL"
+
+// DefaultInterfaceCheckInterval is how often interfaces are checked for changes unless configured
+const DefaultInterfaceCheckInterval = time.Minute
+
+// Event types sent when an interface changes
+const (
+	InterfaceLinkEvent    = "interface_link"    // link went up or down, or the interface appeared or went away
+	InterfaceSpeedEvent   = "interface_speed"   // speed or duplex renegotiated
+	InterfaceAddressEvent = "interface_address" // address added or removed
+)
+
+// linkAbsent is the link state of an interface that doesn't exist
+const linkAbsent = "absent"
+
+// NetworkInterfaces is the inventory sent as the network_interfaces event
+type NetworkInterfaces struct {
+	Interfaces []monitors.InterfaceInfo `json:"interfaces"`
+}
+
+// InterfaceChange is a single change to an interface. For addresses Old is set when
+// one is removed and New when one is added.
+type InterfaceChange struct {
+	Type      string                  `json:"-"`
+	Interface string                  `json:"interface"`
+	Old       string                  `json:"old,omitempty"`
+	New       string                  `json:"new,omitempty"`
+	Info      *monitors.InterfaceInfo `json:"info,omitempty"` // the interface now, unless it went away
+}
+
+// InterfaceCheckIntervalFromEnv reads MONKEY_INTERFACE_CHECK_INTERVAL, on error the default is returned
+func InterfaceCheckIntervalFromEnv() (time.Duration, error) {
+	env := os.Getenv(InterfaceCheckIntervalEnvVar)
+	if env == "" {
+		return DefaultInterfaceCheckInterval, nil
+	}
+	seconds, err := strconv.Atoi(env)
+	if err != nil || seconds < 0 {
+		return DefaultInterfaceCheckInterval, fmt.Errorf("invalid %s %q", InterfaceCheckIntervalEnvVar, env)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// InterfaceWatcher compares each inventory with the one before. Every interface is
+// watched whether or not its traffic is counted, a bridge, bond or VLAN is often where
+// the host's address lives, except loopback and veths, so containers starting and
+// stopping don't send a link event for each veth they come and go with.
+type InterfaceWatcher struct {
+	filter monitors.NetFilter
+	mutex  sync.Mutex
+	last   map[string]monitors.InterfaceInfo
+}
+
+// NewInterfaceWatcher creates a watcher, filter only marks which interfaces are counted
+func NewInterfaceWatcher(filter monitors.NetFilter) *InterfaceWatcher {
+	return &InterfaceWatcher{filter: filter}
+}
+
+// Check takes an inventory and returns what changed since the previous one.
+// The first check only records the baseline.
+func (w *InterfaceWatcher) Check() ([]InterfaceChange, error) {
+	all, err := monitors.GetInterfaces(w.filter)
+	if err != nil {
+		return nil, err
+	}
+	return w.compare(all), nil
+}
+
+// compare returns what changed between the previous inventory and all
+func (w *InterfaceWatcher) compare(all []monitors.InterfaceInfo) []InterfaceChange {
+	var infos []monitors.InterfaceInfo
+	current := make(map[string]monitors.InterfaceInfo, len(all))
+	for _, info := range all {
+		if info.Kind != monitors.IfaceLoopback && info.Kind != monitors.IfaceVeth {
+			infos = append(infos, info)
+			current[info.Name] = info
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.last == nil {
+		w.last = current
+		return nil
+	}
+
+	var changes []InterfaceChange
+	for _, info := range infos {
+		info := info
+		prev, existed := w.last[info.Name]
+		if !existed {
+			prev = monitors.InterfaceInfo{OperState: linkAbsent}
+		}
+
+		if linkState(prev) != linkState(info) {
+			changes = append(changes, InterfaceChange{Type: InterfaceLinkEvent, Interface: info.Name,
+				Old: linkState(prev), New: linkState(info), Info: &info})
+		}
+		// speed is unknown while the link is down, that's a link change not a renegotiation
+		if existed && prev.SpeedMbps != 0 && info.SpeedMbps != 0 && prev.Speed() != info.Speed() {
+			changes = append(changes, InterfaceChange{Type: InterfaceSpeedEvent, Interface: info.Name,
+				Old: prev.Speed(), New: info.Speed(), Info: &info})
+		}
+		for _, addr := range difference(info.Addresses, prev.Addresses) {
+			changes = append(changes, InterfaceChange{Type: InterfaceAddressEvent, Interface: info.Name,
+				New: addr, Info: &info})
+		}
+		for _, addr := range difference(prev.Addresses, info.Addresses) {
+			changes = append(changes, InterfaceChange{Type: InterfaceAddressEvent, Interface: info.Name,
+				Old: addr, Info: &info})
+		}
+	}
+	for name, prev := range w.last {
+		if _, ok := current[name]; !ok {
+			changes = append(changes, InterfaceChange{Type: InterfaceLinkEvent, Interface: name,
+				Old: linkState(prev), New: linkAbsent})
+		}
+	}
+
+	for _, change := range changes {
+		log.Info("Network interface changed", "type", change.Type, "interface", change.Interface,
+			"old", change.Old, "new", change.New)
+	}
+	w.last = current
+	return changes
+}
+
+// linkState is the operstate, noting a missing carrier that operstate doesn't show
+func linkState(info monitors.InterfaceInfo) string {
+	if info.OperState == "up" && info.Carrier != nil && !*info.Carrier {
+		return "no-carrier"
+	}
+	return info.OperState
+}
+
+// difference returns the items of a missing from b
+func difference(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, item := range b {
+		in[item] = true
+	}
+	var missing []string
+	for _, item := range a {
+		if !in[item] {
+			missing = append(missing, item)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,62 @@
+package events
+
+import (
+	"go_monitor/monitors"
+	"reflect"
+	"testing"
+)
+
+// changeSummaries lists changes as type interface old>new, in order
+func changeSummaries(changes []InterfaceChange) []string {
+	var summaries []string
+	for _, c := range changes {
+		summaries = append(summaries, c.Type+" "+c.Interface+" "+c.Old+">"+c.New)
+	}
+	return summaries
+}
+
+func TestInterfaceWatcherCompare(t *testing.T) {
+	up := true
+	eth0 := monitors.InterfaceInfo{Name: "eth0", Kind: monitors.IfacePhysical, Counted: true, OperState: "up",
+		Carrier: &up, SpeedMbps: 1000, Duplex: "full", Addresses: []string{}}
+	br0 := monitors.InterfaceInfo{Name: "br0", Kind: monitors.IfaceBridge, OperState: "up",
+		Addresses: []string{"192.0.2.10/24"}}
+	bond0 := monitors.InterfaceInfo{Name: "bond0", Kind: monitors.IfaceBond, OperState: "up", Addresses: []string{}}
+	lo := monitors.InterfaceInfo{Name: "lo", Kind: monitors.IfaceLoopback, OperState: "unknown",
+		Addresses: []string{"127.0.0.1/8"}}
+	veth := monitors.InterfaceInfo{Name: "veth1a2b", Kind: monitors.IfaceVeth, OperState: "up", Addresses: []string{}}
+
+	br0Moved := br0
+	br0Moved.Addresses = []string{"192.0.2.20/24"}
+	bond0Down := bond0
+	bond0Down.OperState = "down"
+	eth0Slow := eth0
+	eth0Slow.SpeedMbps = 100
+	loMoved := lo
+	loMoved.Addresses = []string{"127.0.0.2/8"}
+
+	w := NewInterfaceWatcher(monitors.DefaultNetFilter())
+	inventories := []struct {
+		name       string
+		interfaces []monitors.InterfaceInfo
+		want       []string
+	}{
+		{"baseline", []monitors.InterfaceInfo{br0, bond0, eth0, lo}, nil},
+		{"no change", []monitors.InterfaceInfo{br0, bond0, eth0, lo}, nil},
+		{"address moves on the bridge", []monitors.InterfaceInfo{br0Moved, bond0, eth0, lo},
+			[]string{"interface_address br0 >192.0.2.20/24", "interface_address br0 192.0.2.10/24>"}},
+		{"bond goes down", []monitors.InterfaceInfo{br0Moved, bond0Down, eth0, lo},
+			[]string{"interface_link bond0 up>down"}},
+		{"counted NIC renegotiates", []monitors.InterfaceInfo{br0Moved, bond0Down, eth0Slow, lo},
+			[]string{"interface_speed eth0 1000Mb/s full>100Mb/s full"}},
+		{"veths and loopback aren't watched", []monitors.InterfaceInfo{br0Moved, bond0Down, eth0Slow, loMoved, veth}, nil},
+		{"bridge goes away", []monitors.InterfaceInfo{bond0Down, eth0Slow, loMoved},
+			[]string{"interface_link br0 up>absent"}},
+	}
+	for _, inventory := range inventories {
+		got := changeSummaries(w.compare(inventory.interfaces))
+		if !reflect.DeepEqual(got, inventory.want) {
+			t.Errorf("%s: changes = %q, want %q", inventory.name, got, inventory.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// port_owners.go
+// finds the process behind a socket by matching its inode against the
+// socket:[inode] links in /proc/<pid>/fd
+
+package events
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// processInfo is what's known about the process that owns a socket
+type processInfo struct {
+	pid  int
+	name string
+	exe  string
+}
+
+// socketOwners maps the inodes of the sockets wanted to the processes holding them.
+// Processes the agent isn't allowed to look into are skipped, so without root only
+// sockets of the agent's own user are attributed.
+func socketOwners(inodes map[uint64]bool) map[uint64]processInfo {
+	owners := make(map[uint64]processInfo)
+	if len(inodes) == 0 {
+		return owners
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return owners
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil || !inodes[inode] {
+				continue
+			}
+			if _, found := owners[inode]; found {
+				// shared with a child, e.g. a preforked worker, keep the first found
+				continue
+			}
+			owners[inode] = readProcessInfo(pid)
+		}
+		if len(owners) == len(inodes) {
+			break
+		}
+	}
+	return owners
+}
+
+// readProcessInfo reads the name and executable of a process, either may be unavailable
+func readProcessInfo(pid int) processInfo {
+	info := processInfo{pid: pid}
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		info.name = strings.TrimSpace(string(comm))
+	}
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		info.exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+	return info
+}
+
+// userNames caches uid lookups, /etc/passwd doesn't change often and there are few users
+var (
+	userNamesMutex sync.Mutex
+	userNames      = make(map[string]string)
+)
+
+// userName returns the name for a uid, or the uid itself if it has none
+func userName(uid string) string {
+	userNamesMutex.Lock()
+	defer userNamesMutex.Unlock()
+	if name, ok := userNames[uid]; ok {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	userNames[uid] = name
+	return name
+}
@@ -0,0 +1,271 @@
+// port_policy.go
+// checks each open ports scan against the ports a host is expected to listen on
+// and the ones that must never be reachable from outside it
+
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default file holding the host's port policy
+const DefaultPortPolicyFile = "/opt/monitor-monkey/port-policy.json"
+
+// Environment variable name to override the default port policy file
+const PortPolicyFileEnvVar = "MONKEY_PORT_POLICY_FILE"
+
+// Event types sent when a port breaks the policy and when it no longer does
+const (
+	PortPolicyViolationEvent = "port_policy_violation"
+	PortPolicyResolvedEvent  = "port_policy_resolved"
+)
+
+// Rules a port can break
+const (
+	RuleExpected        = "expected"         // an expected port isn't listening
+	RuleForbiddenPublic = "forbidden_public" // a forbidden port is listening on a public address
+)
+
+// PortPolicy lists ports as "tcp/443", "udp/53" or just "443" for TCP. It's read from
+// the policy file, or sent by the API as the PortPolicy of the custom configuration.
+type PortPolicy struct {
+	Expected        []string `json:"expected,omitempty"`         // must always be listening, on any address including loopback
+	ForbiddenPublic []string `json:"forbidden_public,omitempty"` // may only listen on loopback or private addresses
+}
+
+// PortViolation is a port breaking a rule of the policy. An expected port that
+// isn't listening has no address or process.
+type PortViolation struct {
+	Rule     string `json:"rule"`
+	Reason   string `json:"reason"`
+	Protocol string `json:"protocol"`
+	PortInfo
+}
+
+// portRule is a parsed policy entry
+type portRule struct {
+	protocol string
+	port     int
+}
+
+func (r portRule) String() string {
+	return r.protocol + "/" + strconv.Itoa(r.port)
+}
+
+// parsePortRules parses the entries of one policy list
+func parsePortRules(list string, entries []string) ([]portRule, error) {
+	rules := make([]portRule, 0, len(entries))
+	for _, entry := range entries {
+		protocol, port := "tcp", strings.TrimSpace(entry)
+		if p, n, found := strings.Cut(port, "/"); found {
+			protocol, port = strings.ToLower(p), n
+		}
+		number, err := strconv.Atoi(port)
+		if err != nil || number < 1 || number > 65535 || (protocol != "tcp" && protocol != "udp") {
+			return nil, fmt.Errorf("invalid %s port %q", list, entry)
+		}
+		rules = append(rules, portRule{protocol: protocol, port: number})
+	}
+	return rules, nil
+}
+
+// LoadPortPolicy reads the policy file named by MONKEY_PORT_POLICY_FILE. A host
+// without a policy file has an empty policy, that's not an error.
+func LoadPortPolicy() (PortPolicy, error) {
+	var policy PortPolicy
+	path := os.Getenv(PortPolicyFileEnvVar)
+	if path == "" {
+		path = DefaultPortPolicyFile
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return policy, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// PortPolicyChecker evaluates scans against the policy, remembering the violations
+// already reported so each is sent once when it starts and once when it ends
+type PortPolicyChecker struct {
+	mutex     sync.Mutex
+	local     PortPolicy // from the policy file, used whenever the API sends none
+	expected  []portRule
+	forbidden map[portRule]bool
+	active    map[string]PortViolation
+}
+
+// NewPortPolicyChecker creates a checker with an empty policy
+func NewPortPolicyChecker() *PortPolicyChecker {
+	return &PortPolicyChecker{forbidden: make(map[portRule]bool), active: make(map[string]PortViolation)}
+}
+
+// SetLocalPolicy sets the policy read from the policy file, which is checked
+// until the API sends one and again whenever it stops sending it
+func (c *PortPolicyChecker) SetLocalPolicy(policy PortPolicy) error {
+	if err := c.setRules(policy); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.local = policy
+	return nil
+}
+
+// SetPolicy replaces the policy, the next scan is checked against it. The API sends
+// its policy with every update, one that's the same as the current policy is ignored
+// and an empty one brings back the local policy. An invalid policy is rejected and
+// the current one kept.
+func (c *PortPolicyChecker) SetPolicy(policy PortPolicy) error {
+	if len(policy.Expected) == 0 && len(policy.ForbiddenPublic) == 0 {
+		c.mutex.Lock()
+		policy = c.local
+		c.mutex.Unlock()
+	}
+	return c.setRules(policy)
+}
+
+// setRules parses policy and makes it the one scans are checked against
+func (c *PortPolicyChecker) setRules(policy PortPolicy) error {
+	expected, err := parsePortRules("expected", policy.Expected)
+	if err != nil {
+		return err
+	}
+	forbiddenRules, err := parsePortRules("forbidden_public", policy.ForbiddenPublic)
+	if err != nil {
+		return err
+	}
+	forbidden := make(map[portRule]bool, len(forbiddenRules))
+	for _, rule := range forbiddenRules {
+		forbidden[rule] = true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if slices.Equal(c.expected, expected) && maps.Equal(c.forbidden, forbidden) {
+		return nil
+	}
+	c.expected = expected
+	c.forbidden = forbidden
+	log.Info("Port policy set", "expected", len(expected), "forbidden_public", len(forbidden))
+	return nil
+}
+
+// Check returns the violations that started and ended since the previous scan
+func (c *PortPolicyChecker) Check(ports OpenPorts) (started, ended []PortViolation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current := make(map[string]PortViolation)
+	// loopback listeners can't break the forbidden rule but do satisfy an expected one
+	listening := make(map[portRule]bool, len(ports.loopback))
+	for rule := range ports.loopback {
+		listening[rule] = true
+	}
+	for _, list := range []struct {
+		protocol string
+		ports    []PortInfo
+	}{{"tcp", ports.TCP}, {"udp", ports.UDP}} {
+		for _, port := range list.ports {
+			rule := portRule{protocol: list.protocol, port: port.Port}
+			listening[rule] = true
+			if !c.forbidden[rule] || !isPublicAddress(port.Address) {
+				continue
+			}
+			reason := fmt.Sprintf("%s is listening on public address %s", rule, port.Address)
+			if port.Process != "" {
+				reason += " (" + port.Process + ")"
+			}
+			key := RuleForbiddenPublic + "/" + list.protocol + "/" + port.Family + "/" + net.JoinHostPort(port.Address, strconv.Itoa(port.Port))
+			current[key] = PortViolation{Rule: RuleForbiddenPublic, Reason: reason, Protocol: list.protocol, PortInfo: port}
+		}
+	}
+
+	var tcpServices, udpServices map[int]string
+	for _, rule := range c.expected {
+		if listening[rule] {
+			continue
+		}
+		if tcpServices == nil {
+			tcpServices, udpServices, _ = loadServices()
+		}
+		service := tcpServices[rule.port]
+		if rule.protocol == "udp" {
+			service = udpServices[rule.port]
+		}
+		current[RuleExpected+"/"+rule.String()] = PortViolation{
+			Rule:     RuleExpected,
+			Reason:   fmt.Sprintf("expected %s is not listening", rule),
+			Protocol: rule.protocol,
+			PortInfo: PortInfo{Port: rule.port, Service: service},
+		}
+	}
+
+	for key, violation := range current {
+		if _, ok := c.active[key]; !ok {
+			started = append(started, violation)
+			log.Warn("Port policy violation", "rule", violation.Rule, "reason", violation.Reason)
+		}
+	}
+	for key, violation := range c.active {
+		if _, ok := current[key]; !ok {
+			ended = append(ended, violation)
+			log.Info("Port policy violation resolved", "rule", violation.Rule, "reason", violation.Reason)
+		}
+	}
+	c.active = current
+
+	// sorted so the events come out in a stable order
+	sortViolations(started)
+	sortViolations(ended)
+	return started, ended
+}
+
+// sortViolations orders violations by rule, port, address and family
+func sortViolations(violations []PortViolation) {
+	sort.Slice(violations, func(i, j int) bool {
+		a, b := violations[i], violations[j]
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Address != b.Address {
+			return a.Address < b.Address
+		}
+		return a.Family < b.Family
+	})
+}
+
+// isPublicAddress reports whether a socket bound to address can be reached from
+// outside the host's private networks: a wildcard or a public unicast address
+func isPublicAddress(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	if ip.IsUnspecified() {
+		return true
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
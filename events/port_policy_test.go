@@ -0,0 +1,247 @@
+package events
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// violationNames lists violations as rule protocol/port@address, in order
+func violationNames(violations []PortViolation) []string {
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Rule+" "+v.Protocol+"/"+strconv.Itoa(v.Port)+"@"+v.Address)
+	}
+	return names
+}
+
+func TestPortPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PortPolicy
+		ports  OpenPorts
+		want   []string
+	}{
+		{
+			name: "empty policy",
+			ports: OpenPorts{TCP: []PortInfo{
+				{Port: 22, Address: "0.0.0.0", Family: "ipv4"},
+			}},
+		},
+		{
+			name:   "expected port listening",
+			policy: PortPolicy{Expected: []string{"22", "udp/53"}},
+			ports: OpenPorts{
+				TCP: []PortInfo{{Port: 22, Address: "0.0.0.0", Family: "ipv4"}},
+				UDP: []PortInfo{{Port: 53, Address: "10.0.0.1", Family: "ipv4"}},
+			},
+		},
+		{
+			name:   "expected port missing",
+			policy: PortPolicy{Expected: []string{"tcp/443", "udp/53"}},
+			ports:  OpenPorts{TCP: []PortInfo{{Port: 53, Address: "0.0.0.0", Family: "ipv4"}}},
+			want:   []string{"expected udp/53@", "expected tcp/443@"},
+		},
+		{
+			name:   "expected port on loopback only",
+			policy: PortPolicy{Expected: []string{"5432"}},
+			ports:  OpenPorts{loopback: map[portRule]bool{{protocol: "tcp", port: 5432}: true}},
+		},
+		{
+			name:   "expected port on loopback of the other protocol",
+			policy: PortPolicy{Expected: []string{"5432"}},
+			ports:  OpenPorts{loopback: map[portRule]bool{{protocol: "udp", port: 5432}: true}},
+			want:   []string{"expected tcp/5432@"},
+		},
+		{
+			name:   "forbidden port on public addresses",
+			policy: PortPolicy{ForbiddenPublic: []string{"6379", "udp/161"}},
+			ports: OpenPorts{
+				TCP: []PortInfo{
+					{Port: 6379, Address: "0.0.0.0", Family: "ipv4"},
+					{Port: 6379, Address: "::", Family: "ipv6"},
+					{Port: 6379, Address: "203.0.113.7", Family: "ipv4"},
+				},
+				UDP: []PortInfo{{Port: 161, Address: "2001:db8::1", Family: "ipv6"}},
+			},
+			want: []string{
+				"forbidden_public udp/161@2001:db8::1",
+				"forbidden_public tcp/6379@0.0.0.0",
+				"forbidden_public tcp/6379@203.0.113.7",
+				"forbidden_public tcp/6379@::",
+			},
+		},
+		{
+			name:   "forbidden port on private and loopback addresses",
+			policy: PortPolicy{ForbiddenPublic: []string{"6379"}},
+			ports: OpenPorts{
+				TCP: []PortInfo{
+					{Port: 6379, Address: "10.0.0.5", Family: "ipv4"},
+					{Port: 6379, Address: "192.168.1.2", Family: "ipv4"},
+					{Port: 6379, Address: "fd00::2", Family: "ipv6"},
+					{Port: 6379, Address: "fe80::1", Family: "ipv6"},
+				},
+				loopback: map[portRule]bool{{protocol: "tcp", port: 6379}: true},
+			},
+		},
+		{
+			name:   "forbidden rule is per protocol",
+			policy: PortPolicy{ForbiddenPublic: []string{"udp/6379"}},
+			ports:  OpenPorts{TCP: []PortInfo{{Port: 6379, Address: "0.0.0.0", Family: "ipv4"}}},
+		},
+		{
+			name:   "same address on both families",
+			policy: PortPolicy{ForbiddenPublic: []string{"80"}},
+			ports: OpenPorts{TCP: []PortInfo{
+				{Port: 80, Address: "203.0.113.7", Family: "ipv4"},
+				{Port: 80, Address: "203.0.113.7", Family: "ipv6"},
+			}},
+			want: []string{"forbidden_public tcp/80@203.0.113.7", "forbidden_public tcp/80@203.0.113.7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewPortPolicyChecker()
+			if err := c.SetPolicy(tt.policy); err != nil {
+				t.Fatal(err)
+			}
+			started, ended := c.Check(tt.ports)
+			if got := violationNames(started); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("started = %q, want %q", got, tt.want)
+			}
+			if len(ended) != 0 {
+				t.Errorf("ended = %q on the first scan", violationNames(ended))
+			}
+		})
+	}
+}
+
+func TestPortPolicyCheckSequence(t *testing.T) {
+	c := NewPortPolicyChecker()
+	if err := c.SetPolicy(PortPolicy{Expected: []string{"22"}, ForbiddenPublic: []string{"6379"}}); err != nil {
+		t.Fatal(err)
+	}
+	sshd := PortInfo{Port: 22, Address: "0.0.0.0", Family: "ipv4"}
+	redis := PortInfo{Port: 6379, Address: "0.0.0.0", Family: "ipv4"}
+
+	scans := []struct {
+		name         string
+		ports        OpenPorts
+		started, end []string
+	}{
+		{"all fine", OpenPorts{TCP: []PortInfo{sshd}}, nil, nil},
+		{"sshd stops, redis goes public", OpenPorts{TCP: []PortInfo{redis}},
+			[]string{"expected tcp/22@", "forbidden_public tcp/6379@0.0.0.0"}, nil},
+		{"nothing changed", OpenPorts{TCP: []PortInfo{redis}}, nil, nil},
+		{"sshd back", OpenPorts{TCP: []PortInfo{sshd, redis}}, nil, []string{"expected tcp/22@"}},
+		{"redis back on loopback", OpenPorts{TCP: []PortInfo{sshd}, loopback: map[portRule]bool{{"tcp", 6379}: true}},
+			nil, []string{"forbidden_public tcp/6379@0.0.0.0"}},
+	}
+	for _, scan := range scans {
+		started, ended := c.Check(scan.ports)
+		if got := violationNames(started); !reflect.DeepEqual(got, scan.started) {
+			t.Errorf("%s: started = %q, want %q", scan.name, got, scan.started)
+		}
+		if got := violationNames(ended); !reflect.DeepEqual(got, scan.end) {
+			t.Errorf("%s: ended = %q, want %q", scan.name, got, scan.end)
+		}
+	}
+}
+
+func TestPortPolicySetPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  PortPolicy
+		wantErr bool
+	}{
+		{"empty", PortPolicy{}, false},
+		{"protocols", PortPolicy{Expected: []string{"22", "tcp/443", "UDP/53", " 80 "}}, false},
+		{"port 0", PortPolicy{Expected: []string{"0"}}, true},
+		{"port too high", PortPolicy{ForbiddenPublic: []string{"65536"}}, true},
+		{"unknown protocol", PortPolicy{ForbiddenPublic: []string{"sctp/80"}}, true},
+		{"not a number", PortPolicy{Expected: []string{"ssh"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewPortPolicyChecker()
+			if err := c.SetPolicy(PortPolicy{Expected: []string{"8080"}}); err != nil {
+				t.Fatal(err)
+			}
+			err := c.SetPolicy(tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			// a rejected policy keeps the current one
+			started, _ := c.Check(OpenPorts{})
+			if got := violationNames(started); tt.wantErr && !reflect.DeepEqual(got, []string{"expected tcp/8080@"}) {
+				t.Errorf("after the invalid policy started = %q, want the current policy's", got)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"0.0.0.0", true},
+		{"::", true},
+		{"203.0.113.7", true},
+		{"2001:db8::1", true},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"not an address", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicAddress(tt.address); got != tt.want {
+			t.Errorf("isPublicAddress(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestPortPolicyRevertsToLocal(t *testing.T) {
+	c := NewPortPolicyChecker()
+	if err := c.SetLocalPolicy(PortPolicy{Expected: []string{"22"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetLocalPolicy(PortPolicy{Expected: []string{"ssh"}}); err == nil {
+		t.Fatal("invalid local policy accepted")
+	}
+	if started, _ := c.Check(OpenPorts{}); !reflect.DeepEqual(violationNames(started), []string{"expected tcp/22@"}) {
+		t.Fatalf("local policy: started = %q", violationNames(started))
+	}
+
+	// main passes an empty policy when the API response has none
+	steps := []struct {
+		name           string
+		policy         PortPolicy
+		started, ended []string
+	}{
+		{"API policy replaces the local one", PortPolicy{Expected: []string{"443"}},
+			[]string{"expected tcp/443@"}, []string{"expected tcp/22@"}},
+		{"API policy missing", PortPolicy{},
+			[]string{"expected tcp/22@"}, []string{"expected tcp/443@"}},
+		{"API policy again", PortPolicy{Expected: []string{"udp/53"}},
+			[]string{"expected udp/53@"}, []string{"expected tcp/22@"}},
+		{"API policy empty", PortPolicy{Expected: []string{}, ForbiddenPublic: []string{}},
+			[]string{"expected tcp/22@"}, []string{"expected udp/53@"}},
+	}
+	for _, step := range steps {
+		if err := c.SetPolicy(step.policy); err != nil {
+			t.Fatal(err)
+		}
+		started, ended := c.Check(OpenPorts{})
+		if got := violationNames(started); !reflect.DeepEqual(got, step.started) {
+			t.Errorf("%s: started = %q, want %q", step.name, got, step.started)
+		}
+		if got := violationNames(ended); !reflect.DeepEqual(got, step.ended) {
+			t.Errorf("%s: ended = %q, want %q", step.name, got, step.ended)
+		}
+	}
+}
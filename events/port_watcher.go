@@ -0,0 +1,95 @@
+// port_watcher.go
+// compares each open ports scan with the one before, so a new listener is
+// reported within minutes rather than in the next daily snapshot
+
+package events
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Environment variable setting how often ports are scanned for changes
+const PortScanIntervalEnvVar = "MONKEY_PORT_SCAN_INTERVAL" // seconds, default 60, 0 only sends the daily snapshot
+
+// DefaultPortScanInterval is how often ports are scanned for changes
+const DefaultPortScanInterval = time.Minute
+
+// Event types sent when a port starts or stops listening
+const (
+	PortOpenedEvent = "port_opened"
+	PortClosedEvent = "port_closed"
+)
+
+// PortChange is a port that started or stopped listening
+type PortChange struct {
+	Protocol string `json:"protocol"` // tcp or udp
+	PortInfo
+}
+
+// PortScanIntervalFromEnv reads MONKEY_PORT_SCAN_INTERVAL, on error the default is returned
+func PortScanIntervalFromEnv() (time.Duration, error) {
+	env := os.Getenv(PortScanIntervalEnvVar)
+	if env == "" {
+		return DefaultPortScanInterval, nil
+	}
+	seconds, err := strconv.Atoi(env)
+	if err != nil || seconds < 0 {
+		return DefaultPortScanInterval, fmt.Errorf("invalid %s %q", PortScanIntervalEnvVar, env)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// PortWatcher remembers the ports of the previous scan
+type PortWatcher struct {
+	mutex sync.Mutex
+	last  map[string]PortChange
+}
+
+// NewPortWatcher creates a watcher, the first scan it sees only sets the baseline
+func NewPortWatcher() *PortWatcher {
+	return &PortWatcher{}
+}
+
+// Check returns the ports opened and closed since the previous scan. A port is its
+// protocol, family, bind address and number, a different process taking it over isn't a change.
+func (w *PortWatcher) Check(ports OpenPorts) (opened, closed []PortChange) {
+	current := make(map[string]PortChange)
+	for _, list := range []struct {
+		protocol string
+		ports    []PortInfo
+	}{{"tcp", ports.TCP}, {"udp", ports.UDP}} {
+		for _, port := range list.ports {
+			key := list.protocol + "/" + port.Family + "/" + net.JoinHostPort(port.Address, strconv.Itoa(port.Port))
+			current[key] = PortChange{Protocol: list.protocol, PortInfo: port}
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.last == nil {
+		w.last = current
+		return nil, nil
+	}
+
+	for key, port := range current {
+		if _, ok := w.last[key]; !ok {
+			opened = append(opened, port)
+			log.Info("Port opened", "protocol", port.Protocol, "family", port.Family, "address", port.Address, "port", port.Port,
+				"process", port.Process, "pid", port.PID)
+		}
+	}
+	for key, port := range w.last {
+		if _, ok := current[key]; !ok {
+			closed = append(closed, port)
+			log.Info("Port closed", "protocol", port.Protocol, "family", port.Family, "address", port.Address, "port", port.Port,
+				"process", port.Process, "pid", port.PID)
+		}
+	}
+	w.last = current
+	return opened, closed
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// changeNames lists port changes as protocol/port@address/family, sorted
+func changeNames(changes []PortChange) []string {
+	var names []string
+	for _, c := range changes {
+		names = append(names, c.Protocol+"/"+strconv.Itoa(c.Port)+"@"+c.Address+"/"+c.Family)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPortWatcherCheck(t *testing.T) {
+	sshd := PortInfo{Port: 22, Address: "0.0.0.0", Family: "ipv4", Process: "sshd", PID: 100}
+	sshd6 := PortInfo{Port: 22, Address: "::", Family: "ipv6", Process: "sshd", PID: 100}
+	dns := PortInfo{Port: 53, Address: "10.0.0.1", Family: "ipv4", Process: "unbound", PID: 200}
+	web := PortInfo{Port: 80, Address: "10.0.0.1", Family: "ipv4", Process: "nginx", PID: 300}
+	web6 := PortInfo{Port: 80, Address: "10.0.0.1", Family: "ipv6", Process: "nginx", PID: 300} // IPv4-mapped
+	webRestarted := web
+	webRestarted.PID = 301
+
+	w := NewPortWatcher()
+	scans := []struct {
+		name           string
+		ports          OpenPorts
+		opened, closed []string
+	}{
+		{"baseline", OpenPorts{TCP: []PortInfo{sshd, web}, UDP: []PortInfo{dns}}, nil, nil},
+		{"no change", OpenPorts{TCP: []PortInfo{sshd, web}, UDP: []PortInfo{dns}}, nil, nil},
+		{"new process on the same port", OpenPorts{TCP: []PortInfo{sshd, webRestarted}, UDP: []PortInfo{dns}}, nil, nil},
+		{"IPv6 listener added", OpenPorts{TCP: []PortInfo{sshd, sshd6, web}, UDP: []PortInfo{dns}},
+			[]string{"tcp/22@::/ipv6"}, nil},
+		{"same address on the other family", OpenPorts{TCP: []PortInfo{sshd, sshd6, web, web6}, UDP: []PortInfo{dns}},
+			[]string{"tcp/80@10.0.0.1/ipv6"}, nil},
+		{"v4 listener gone, v6 still there", OpenPorts{TCP: []PortInfo{sshd, sshd6, web6}, UDP: []PortInfo{dns}},
+			nil, []string{"tcp/80@10.0.0.1/ipv4"}},
+		{"protocols are apart", OpenPorts{TCP: []PortInfo{sshd, sshd6, web6, dns}},
+			[]string{"tcp/53@10.0.0.1/ipv4"}, []string{"udp/53@10.0.0.1/ipv4"}},
+		{"everything closed", OpenPorts{}, nil,
+			[]string{"tcp/22@0.0.0.0/ipv4", "tcp/22@::/ipv6", "tcp/53@10.0.0.1/ipv4", "tcp/80@10.0.0.1/ipv6"}},
+	}
+	for _, scan := range scans {
+		opened, closed := w.Check(scan.ports)
+		if got := changeNames(opened); !reflect.DeepEqual(got, scan.opened) {
+			t.Errorf("%s: opened = %q, want %q", scan.name, got, scan.opened)
+		}
+		if got := changeNames(closed); !reflect.DeepEqual(got, scan.closed) {
+			t.Errorf("%s: closed = %q, want %q", scan.name, got, scan.closed)
+		}
+	}
+}
+
+func TestPortWatcherReportsProcess(t *testing.T) {
+	w := NewPortWatcher()
+	w.Check(OpenPorts{})
+	opened, _ := w.Check(OpenPorts{UDP: []PortInfo{{Port: 123, Address: "0.0.0.0", Family: "ipv4", Process: "chronyd", PID: 9}}})
+	if len(opened) != 1 || opened[0].Protocol != "udp" || opened[0].Process != "chronyd" || opened[0].PID != 9 {
+		t.Errorf("opened = %+v, want udp/123 with its process", opened)
+	}
+}
+
+func TestPortScanIntervalFromEnv(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"", 60, false},
+		{"300", 300, false},
+		{"0", 0, false},
+		{"-1", 60, true},
+		{"1m", 60, true},
+	}
+	for _, tt := range tests {
+		t.Setenv(PortScanIntervalEnvVar, tt.env)
+		got, err := PortScanIntervalFromEnv()
+		if (err != nil) != tt.wantErr || int(got.Seconds()) != tt.want {
+			t.Errorf("%s=%q: got %v, %v, want %ds, error %v", PortScanIntervalEnvVar, tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -3,8 +3,8 @@ package events
 import (
     "bufio"
     "encoding/hex"
-    "encoding/json"
     "fmt"
+    "go_monitor/logger"
     "net"
     "os"
     "sort"
@@ -12,16 +12,28 @@ import (
     "strings"
 )
 
-// PortInfo holds information about an open port.
+var log = logger.For("events")
+
+// PortInfo holds information about an open port, what it is bound to and
+// the process listening on it. Owners are only known when the agent runs as root.
 type PortInfo struct {
     Port    int    `json:"port"`
     Service string `json:"service,omitempty"`
+    Address string `json:"address"`
+    Family  string `json:"family"` // ipv4 or ipv6
+    PID     int    `json:"pid,omitempty"`
+    Process string `json:"process,omitempty"`
+    User    string `json:"user,omitempty"`
+    Exe     string `json:"exe,omitempty"`
 }
 
 // OpenPorts holds the lists of open TCP and UDP ports.
 type OpenPorts struct {
     TCP []PortInfo `json:"tcp"`
     UDP []PortInfo `json:"udp"`
+
+    // ports listening on loopback, left out of the lists but still there for the port policy
+    loopback map[portRule]bool
 }
 
 // loadServices parses /etc/services to map ports to service names for TCP and UDP.
@@ -93,129 +105,142 @@ func parseLocalAddress(addr string) (net.IP, int, error) {
         if err != nil {
             return nil, 0, fmt.Errorf("invalid IP hex: %s", ipHex)
         }
+        // Four little-endian 32 bit words, reverse the bytes of each
+        for word := 0; word < 16; word += 4 {
+            ipBytes[word], ipBytes[word+3] = ipBytes[word+3], ipBytes[word]
+            ipBytes[word+1], ipBytes[word+2] = ipBytes[word+2], ipBytes[word+1]
+        }
         return net.IP(ipBytes), int(port), nil
     }
     return nil, 0, fmt.Errorf("unsupported IP format: %s", ipHex)
 }
 
-// getOpenPorts reads a /proc/net/* file and returns a list of open ports.
-func getOpenPorts(procFile string, isTCP bool) ([]int, error) {
+// listeningSocket is a listening TCP or bound UDP socket from /proc/net/*
+type listeningSocket struct {
+    ip     net.IP
+    port   int
+    family string // from the table it's in, an IPv4-mapped address in tcp6 is still ipv6
+    uid    string
+    inode  uint64
+}
+
+// getOpenPorts reads a /proc/net/* file and returns its listening sockets.
+func getOpenPorts(procFile string, isTCP bool) ([]listeningSocket, error) {
     content, err := os.ReadFile(procFile)
     if err != nil {
         return nil, err // Return error if file cannot be read
     }
 
+    family := "ipv4"
+    if strings.HasSuffix(procFile, "6") { // tcp6, udp6
+        family = "ipv6"
+    }
+
     lines := strings.Split(string(content), "\n")
-    ports := make(map[int]bool) // Use map to collect unique ports
+    var sockets []listeningSocket
     for _, line := range lines[1:] { // Skip header line
         fields := strings.Fields(line)
-        if len(fields) < 4 {
+        if len(fields) < 10 {
             continue
         }
         if isTCP && fields[3] != "0A" { // TCP: only LISTEN state (0A)
             continue
         }
-        ip, port, err := parseLocalAddress(fields[1]) // local_address is 2nd field
-        if err != nil {
+        // UDP: only unconnected sockets (07 with no remote address), a connected one
+        // is a client such as a DNS lookup or the agent's own StatsD socket
+        if !isTCP && (fields[3] != "07" || strings.Trim(fields[2], "0:") != "") {
             continue
         }
-        if !ip.IsLoopback() { // Exclude loopback addresses
-            ports[port] = true
-        }
-    }
-    var portList []int
-    for port := range ports {
-        portList = append(portList, port)
-    }
-    return portList, nil
-}
-
-// GetOpenPorts returns information about open TCP and UDP ports.
-func GetOpenPorts() (OpenPorts, error) {
-    // Load service mappings from /etc/services
-    tcpServices, udpServices, err := loadServices()
-    if err != nil {
-        fmt.Fprintf(os.Stderr, "Warning: could not load /etc/services: %v\n", err)
-    }
-
-    // Collect unique open ports for TCP and UDP
-    tcpPorts := make(map[int]bool)
-    udpPorts := make(map[int]bool)
-
-    // Process TCP files (IPv4 and IPv6)
-    for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
-        ports, err := getOpenPorts(file, true)
+        ip, port, err := parseLocalAddress(fields[1]) // local_address is 2nd field
         if err != nil {
-            fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
             continue
         }
-        for _, port := range ports {
-            tcpPorts[port] = true
-        }
+        inode, _ := strconv.ParseUint(fields[9], 10, 64)
+        sockets = append(sockets, listeningSocket{ip: ip, port: port, family: family, uid: fields[7], inode: inode})
     }
+    return sockets, nil
+}
 
-    // Process UDP files (IPv4 and IPv6)
-    for _, file := range []string{"/proc/net/udp", "/proc/net/udp6"} {
-        ports, err := getOpenPorts(file, false)
+// readOpenPorts reads the listening sockets from the IPv4 and IPv6 tables of a protocol,
+// one per bind address and port, and attributes each to its process and user.
+// Sockets bound to loopback are only returned as the port numbers in loopback,
+// connected UDP client sockets are in neither.
+func readOpenPorts(files []string, isTCP bool, services map[int]string) (ports []PortInfo, loopback []int) {
+    seen := make(map[string]bool)
+    var sockets []listeningSocket
+    inodes := make(map[uint64]bool)
+    for _, file := range files {
+        found, err := getOpenPorts(file, isTCP)
         if err != nil {
-            fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", file, err)
+            log.Error("Failed to read socket table", "file", file, "err", err)
             continue
         }
-        for _, port := range ports {
-            udpPorts[port] = true
-        }
-    }
-
-    // Create slices of PortInfo for TCP and UDP
-    var tcpPortInfos []PortInfo
-    for port := range tcpPorts {
-        service, ok := tcpServices[port]
-        if ok {
-            tcpPortInfos = append(tcpPortInfos, PortInfo{Port: port, Service: service})
-        } else {
-            tcpPortInfos = append(tcpPortInfos, PortInfo{Port: port})
-        }
-    }
-
-    var udpPortInfos []PortInfo
-    for port := range udpPorts {
-        service, ok := udpServices[port]
-        if ok {
-            udpPortInfos = append(udpPortInfos, PortInfo{Port: port, Service: service})
-        } else {
-            udpPortInfos = append(udpPortInfos, PortInfo{Port: port})
-        }
-    }
-
-    // Sort the port info slices by port number
-    sort.Slice(tcpPortInfos, func(i, j int) bool {
-        return tcpPortInfos[i].Port < tcpPortInfos[j].Port
-    })
-    sort.Slice(udpPortInfos, func(i, j int) bool {
-        return udpPortInfos[i].Port < udpPortInfos[j].Port
+        for _, socket := range found {
+            if socket.ip.IsLoopback() { // Exclude loopback addresses
+                loopback = append(loopback, socket.port)
+                continue
+            }
+            // several processes can share a port with SO_REUSEPORT, list it once
+            key := socket.family + "/" + net.JoinHostPort(socket.ip.String(), strconv.Itoa(socket.port))
+            if seen[key] {
+                continue
+            }
+            seen[key] = true
+            sockets = append(sockets, socket)
+            inodes[socket.inode] = true
+        }
+    }
+
+    owners := socketOwners(inodes)
+    ports = make([]PortInfo, 0, len(sockets))
+    for _, socket := range sockets {
+        info := PortInfo{
+            Port:    socket.port,
+            Service: services[socket.port],
+            Address: socket.ip.String(),
+            Family:  socket.family,
+            User:    userName(socket.uid),
+        }
+        if owner, ok := owners[socket.inode]; ok {
+            info.PID = owner.pid
+            info.Process = owner.name
+            info.Exe = owner.exe
+        }
+        ports = append(ports, info)
+    }
+
+    // Sort by port number, then address and family
+    sort.Slice(ports, func(i, j int) bool {
+        if ports[i].Port != ports[j].Port {
+            return ports[i].Port < ports[j].Port
+        }
+        if ports[i].Address != ports[j].Address {
+            return ports[i].Address < ports[j].Address
+        }
+        return ports[i].Family < ports[j].Family
     })
-
-    // Create the OpenPorts struct
-    openPorts := OpenPorts{
-        TCP: tcpPortInfos,
-        UDP: udpPortInfos,
-    }
-
-    return openPorts, nil
+    return ports, loopback
 }
 
-// GetOpenPortsJSON returns open ports information as a formatted JSON string
-func GetOpenPortsJSON() (string, error) {
-    openPorts, err := GetOpenPorts()
+// GetOpenPorts returns information about open TCP and UDP ports.
+func GetOpenPorts() (OpenPorts, error) {
+    // Load service mappings from /etc/services
+    tcpServices, udpServices, err := loadServices()
     if err != nil {
-        return "", err
+        log.Warn("Could not load /etc/services", "err", err)
     }
 
-    // Marshal the struct to JSON
-    jsonData, err := json.MarshalIndent(openPorts, "", "  ")
-    if err != nil {
-        return "", err
+    // Create the OpenPorts struct from the IPv4 and IPv6 tables
+    openPorts := OpenPorts{loopback: make(map[portRule]bool)}
+    var tcpLoopback, udpLoopback []int
+    openPorts.TCP, tcpLoopback = readOpenPorts([]string{"/proc/net/tcp", "/proc/net/tcp6"}, true, tcpServices)
+    openPorts.UDP, udpLoopback = readOpenPorts([]string{"/proc/net/udp", "/proc/net/udp6"}, false, udpServices)
+    for _, port := range tcpLoopback {
+        openPorts.loopback[portRule{protocol: "tcp", port: port}] = true
+    }
+    for _, port := range udpLoopback {
+        openPorts.loopback[portRule{protocol: "udp", port: port}] = true
     }
 
-    return string(jsonData), nil
+    return openPorts, nil
 }
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLocalAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantIP   string
+		wantPort int
+		wantErr  bool
+	}{
+		{"00000000:0016", "0.0.0.0", 22, false},
+		{"0100007F:1F90", "127.0.0.1", 8080, false},
+		{"0100000A:0050", "10.0.0.1", 80, false},
+		{"00000000000000000000000000000000:01BB", "::", 443, false},
+		{"00000000000000000000000001000000:0035", "::1", 53, false},
+		{"B80D0120000000000000000001000000:0050", "2001:db8::1", 80, false},
+		{"B80D0120785634120000000001000000:0050", "2001:db8:1234:5678::1", 80, false},
+		{"000080FE000000000000000001000000:0222", "fe80::1", 546, false},
+		{"0000000000000000FFFF00000100000A:0050", "10.0.0.1", 80, false}, // IPv4-mapped
+		{"0100007F", "", 0, true},
+		{"0100007F:XYZ", "", 0, true},
+		{"0100007:0016", "", 0, true},
+		{"ZZ00007F:0016", "", 0, true},
+	}
+	for _, tt := range tests {
+		ip, port, err := parseLocalAddress(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLocalAddress(%q) err = %v, want error %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !ip.Equal(net.ParseIP(tt.wantIP)) || port != tt.wantPort {
+			t.Errorf("parseLocalAddress(%q) = %s, %d, want %s, %d", tt.addr, ip, port, tt.wantIP, tt.wantPort)
+		}
+	}
+}
+
+// procNetHeader is the first line of /proc/net/tcp, skipped by getOpenPorts
+const procNetHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+func TestGetOpenPorts(t *testing.T) {
+	dir := t.TempDir()
+	tables := map[string]string{
+		"tcp": procNetHeader +
+			"   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0 100 0 0 10 0\n" +
+			"   1: 0100000A:0016 0200000A:D431 01 00000000:00000000 02:000A7B2F 00000000     0        0 1002 4 0 20 4 30 10 -1\n" +
+			"   2: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1003 1 0 100 0 0 10 0\n",
+		"tcp6": procNetHeader +
+			"   0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1 0 100 0 0 10 0\n" +
+			"   1: 0000000000000000FFFF00000100000A:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000    33        0 2002 1 0 100 0 0 10 0\n",
+		"udp": procNetHeader +
+			"  10: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 3001 2 0 0\n" +
+			"  11: short line\n" +
+			// a connected client socket, e.g. net.Dial("udp", "192.0.2.1:8125")
+			"  12: 0200000A:BA38 010200C0:1FBD 01 00000000:00000000 00:00000000 00000000     0        0 3002 2 0 0\n" +
+			// unconnected state but a remote address, never a listener
+			"  13: 0200000A:BA39 010200C0:1FBD 07 00000000:00000000 00:00000000 00000000     0        0 3003 2 0 0\n",
+		"udp6": procNetHeader +
+			"   0: 00000000000000000000000000000000:0223 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 4001 2 0 0\n" +
+			"   1: B80D0120000000000000000002000000:D431 B80D0120000000000000000035000000:0035 01 00000000:00000000 00:00000000 00000000     0        0 4002 2 0 0\n",
+	}
+	for name, content := range tables {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file  string
+		isTCP bool
+		want  []listeningSocket
+	}{
+		{"tcp", true, []listeningSocket{
+			{ip: net.ParseIP("0.0.0.0").To4(), port: 22, family: "ipv4", uid: "0", inode: 1001},
+			{ip: net.ParseIP("127.0.0.1").To4(), port: 8080, family: "ipv4", uid: "1000", inode: 1003},
+		}},
+		{"tcp6", true, []listeningSocket{
+			{ip: net.ParseIP("::"), port: 22, family: "ipv6", uid: "0", inode: 2001},
+			// an IPv4-mapped address is still an IPv6 socket
+			{ip: net.ParseIP("10.0.0.1"), port: 80, family: "ipv6", uid: "33", inode: 2002},
+		}},
+		{"udp", false, []listeningSocket{
+			{ip: net.ParseIP("0.0.0.0").To4(), port: 53, family: "ipv4", uid: "101", inode: 3001},
+		}},
+		{"udp6", false, []listeningSocket{
+			{ip: net.ParseIP("::"), port: 547, family: "ipv6", uid: "0", inode: 4001},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := getOpenPorts(filepath.Join(dir, tt.file), tt.isTCP)
+		if err != nil {
+			t.Fatalf("getOpenPorts(%s): %v", tt.file, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("getOpenPorts(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+		for i, socket := range got {
+			want := tt.want[i]
+			if !socket.ip.Equal(want.ip) || socket.port != want.port || socket.family != want.family ||
+				socket.uid != want.uid || socket.inode != want.inode {
+				t.Errorf("getOpenPorts(%s)[%d] = %+v, want %+v", tt.file, i, socket, want)
+			}
+		}
+	}
+
+	if _, err := getOpenPorts(filepath.Join(dir, "missing"), true); err == nil {
+		t.Error("getOpenPorts of a missing file didn't fail")
+	}
+}
+
+func TestReadOpenPortsUDP(t *testing.T) {
+	dir := t.TempDir()
+	udp := filepath.Join(dir, "udp")
+	content := procNetHeader +
+		// 0.0.0.0:53 and 127.0.0.1:5353 are bound, the other two are a resolver's
+		// connected lookups, one of them over loopback
+		"   0: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 3001 2 0 0\n" +
+		"   1: 0100007F:14E9 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 3002 2 0 0\n" +
+		"   2: 0100007F:A028 3500007F:0035 01 00000000:00000000 00:00000000 00000000  1000        0 3003 2 0 0\n" +
+		"   3: 0200000A:A029 08080808:0035 01 00000000:00000000 00:00000000 00000000  1000        0 3004 2 0 0\n"
+	if err := os.WriteFile(udp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ports, loopback := readOpenPorts([]string{udp}, false, map[int]string{53: "domain"})
+	if len(ports) != 1 || ports[0].Port != 53 || ports[0].Address != "0.0.0.0" || ports[0].Service != "domain" {
+		t.Errorf("ports = %+v, want only 0.0.0.0:53", ports)
+	}
+	if len(loopback) != 1 || loopback[0] != 5353 {
+		t.Errorf("loopback = %v, want [5353]", loopback)
+	}
+}
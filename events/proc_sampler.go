@@ -0,0 +1,218 @@
+// proc_sampler.go
+// measures each process's CPU use between two samples of /proc/<pid>/stat, rather
+// than the lifetime average ps reports, which hides a spike in a long running daemon
+
+package events
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat. The kernel always
+// reports them in USER_HZ, which is 100 on every architecture Linux supports.
+const clockTicks = 100
+
+// firstSampleWindow is how long the first sample waits for a second reading, so
+// even the first collection measures CPU use over an interval
+const firstSampleWindow = time.Second
+
+// processUsage is a process's resource use over the interval between two samples
+type processUsage struct {
+	pid        int32
+	startTime  uint64
+	name       string
+	cpuPercent float64 // of one core, like ps, so a busy multithreaded process can pass 100
+	rssKB      uint64
+	hasIO      bool    // only root can read other users' IO counters
+	readRate   float64 // bytes/sec read from storage
+	writeRate  float64
+}
+
+// procStat is what's read from /proc/<pid>/stat
+type procStat struct {
+	name       string
+	cpuTicks   uint64 // user and system time
+	startTime  uint64 // ticks after boot, with the pid it identifies the process
+	rssPages   uint64
+	hasIO      bool
+	readBytes  uint64
+	writeBytes uint64
+}
+
+// processSampler remembers each process's CPU time, and IO if it samples that too,
+// at the previous sample
+type processSampler struct {
+	withIO   bool
+	mutex    sync.Mutex
+	last     map[int32]procStat
+	lastTime time.Time
+}
+
+// sampler is shared by every process collection, the first sample takes its own baseline
+var sampler = &processSampler{}
+
+// sample returns the usage of every running process and the time since the previous sample.
+// A pid whose start time changed was reused by a new process, which like any
+// process started since the previous sample has all its CPU time counted.
+func (s *processSampler) sample() ([]processUsage, time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.last == nil {
+		last, err := readProcStats(s.withIO)
+		if err != nil {
+			return nil, 0, err
+		}
+		s.last, s.lastTime = last, time.Now()
+		time.Sleep(firstSampleWindow)
+	}
+
+	current, err := readProcStats(s.withIO)
+	if err != nil {
+		return nil, 0, err
+	}
+	now := time.Now()
+	interval := now.Sub(s.lastTime)
+	elapsed := interval.Seconds()
+
+	pageKB := uint64(os.Getpagesize() / 1024)
+	usage := make([]processUsage, 0, len(current))
+	for pid, stat := range current {
+		prev, seen := s.last[pid]
+		seen = seen && prev.startTime == stat.startTime
+		ticks, readBytes, writeBytes := stat.cpuTicks, stat.readBytes, stat.writeBytes
+		if seen && prev.cpuTicks <= ticks {
+			ticks -= prev.cpuTicks
+		}
+		if seen && prev.hasIO && prev.readBytes <= readBytes && prev.writeBytes <= writeBytes {
+			readBytes -= prev.readBytes
+			writeBytes -= prev.writeBytes
+		}
+		u := processUsage{pid: pid, startTime: stat.startTime, name: stat.name, rssKB: stat.rssPages * pageKB, hasIO: stat.hasIO}
+		if elapsed > 0 {
+			u.cpuPercent = float64(ticks) / clockTicks / elapsed * 100
+			if u.hasIO {
+				u.readRate = float64(readBytes) / elapsed
+				u.writeRate = float64(writeBytes) / elapsed
+			}
+		}
+		usage = append(usage, u)
+	}
+
+	s.last, s.lastTime = current, now
+	return usage, interval, nil
+}
+
+// readProcStats reads /proc/<pid>/stat of every running process, and /proc/<pid>/io
+// withIO. Processes that exit while they're being read are skipped.
+func readProcStats(withIO bool) (map[int32]procStat, error) {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[int32]procStat, len(procs))
+	for _, proc := range procs {
+		pid, err := strconv.ParseInt(proc.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join("/proc", proc.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		stat, err := parseProcStat(string(content))
+		if err != nil {
+			log.Debug("Skipping unreadable process stat", "pid", pid, "err", err)
+			continue
+		}
+		if withIO {
+			stat.readBytes, stat.writeBytes, stat.hasIO = readProcIO(proc.Name())
+		}
+		stats[int32(pid)] = stat
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("no processes found in /proc")
+	}
+	return stats, nil
+}
+
+// readProcIO reads the bytes a process had read from and written to storage,
+// ok is false when its /proc/<pid>/io can't be read
+func readProcIO(pid string) (readBytes, writeBytes uint64, ok bool) {
+	content, err := os.ReadFile(filepath.Join("/proc", pid, "io"))
+	if err != nil {
+		return 0, 0, false
+	}
+	readBytes, writeBytes = parseProcIO(string(content))
+	return readBytes, writeBytes, true
+}
+
+// parseProcIO parses the read_bytes and write_bytes lines of a /proc/<pid>/io file
+func parseProcIO(content string) (readBytes, writeBytes uint64) {
+	for _, line := range strings.Split(content, "\n") {
+		name, value, found := strings.Cut(line, ": ")
+		if !found {
+			continue
+		}
+		switch name {
+		case "read_bytes":
+			readBytes, _ = strconv.ParseUint(value, 10, 64)
+		case "write_bytes":
+			writeBytes, _ = strconv.ParseUint(value, 10, 64)
+		}
+	}
+	return readBytes, writeBytes
+}
+
+// processUser returns the name of the user a process runs as, the owner of its /proc directory
+func processUser(pid int32) string {
+	info, err := os.Stat(filepath.Join("/proc", strconv.Itoa(int(pid))))
+	if err != nil {
+		return ""
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return userName(strconv.FormatUint(uint64(st.Uid), 10))
+}
+
+// parseProcStat parses a /proc/<pid>/stat line, "pid (comm) state ppid ...". The
+// name can hold spaces and parentheses, so it ends at the last ')'.
+func parseProcStat(content string) (procStat, error) {
+	var stat procStat
+	open := strings.IndexByte(content, '(')
+	end := strings.LastIndexByte(content, ')')
+	if open < 0 || end < open {
+		return stat, fmt.Errorf("malformed stat %q", content)
+	}
+	stat.name = content[open+1 : end]
+
+	// fields from the state on, field 3 in proc(5) is index 0 here
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 22 {
+		return stat, fmt.Errorf("stat has %d fields after the name", len(fields))
+	}
+	utime, err1 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err2 := strconv.ParseUint(fields[12], 10, 64)
+	startTime, err3 := strconv.ParseUint(fields[19], 10, 64)
+	rss, err4 := strconv.ParseInt(fields[21], 10, 64)
+	for _, err := range []error{err1, err2, err3, err4} {
+		if err != nil {
+			return stat, err
+		}
+	}
+	stat.cpuTicks = utime + stime
+	stat.startTime = startTime
+	if rss > 0 {
+		stat.rssPages = uint64(rss)
+	}
+	return stat, nil
+}
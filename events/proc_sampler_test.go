@@ -0,0 +1,143 @@
+package events
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// procStatLine builds a /proc/<pid>/stat line with the given name, CPU times, start time and RSS
+func procStatLine(pid int, name string, utime, stime, startTime, rss string) string {
+	// state ppid pgrp session tty_nr tpgid flags minflt cminflt majflt cmajflt, then
+	// utime stime cutime cstime priority nice num_threads itrealvalue, then
+	// starttime vsize rss and the fields after it
+	fields := []string{"S", "1", "1", "1", "0", "-1", "4194560", "100", "0", "0", "0",
+		utime, stime, "0", "0", "20", "0", "1", "0",
+		startTime, "2703360", rss, "18446744073709551615", "1", "1", "0"}
+	return strconv.Itoa(pid) + " (" + name + ") " + strings.Join(fields, " ") + "\n"
+}
+
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    procStat
+		wantErr bool
+	}{
+		{
+			name:    "simple",
+			content: procStatLine(42, "nginx", "150", "50", "351540", "273"),
+			want:    procStat{name: "nginx", cpuTicks: 200, startTime: 351540, rssPages: 273},
+		},
+		{
+			name:    "name with spaces and parentheses",
+			content: procStatLine(7, "tmux: server (1)", "3", "4", "99", "10"),
+			want:    procStat{name: "tmux: server (1)", cpuTicks: 7, startTime: 99, rssPages: 10},
+		},
+		{
+			name:    "empty name",
+			content: procStatLine(8, "", "0", "0", "1", "0"),
+			want:    procStat{startTime: 1},
+		},
+		{
+			name:    "negative rss",
+			content: procStatLine(9, "zombie", "1", "1", "5", "-1"),
+			want:    procStat{name: "zombie", cpuTicks: 2, startTime: 5},
+		},
+		{
+			name:    "no name",
+			content: "42 nginx S 1 1",
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			content: "42 (nginx) S 1 1 1 0 -1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid CPU time",
+			content: procStatLine(42, "nginx", "x", "50", "351540", "273"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProcStat(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("parseProcStat = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProcStatSelf(t *testing.T) {
+	content, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		t.Skip("no /proc:", err)
+	}
+	stat, err := parseProcStat(string(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.name == "" || stat.startTime == 0 || stat.rssPages == 0 {
+		t.Errorf("parseProcStat of this process = %+v", stat)
+	}
+}
+
+func TestParseProcIO(t *testing.T) {
+	tests := []struct {
+		name                  string
+		content               string
+		wantRead, wantWritten uint64
+	}{
+		{
+			name: "full",
+			content: "rchar: 3980\nwchar: 12\nsyscr: 9\nsyscw: 1\nread_bytes: 4096\n" +
+				"write_bytes: 8192\ncancelled_write_bytes: 0\n",
+			wantRead:    4096,
+			wantWritten: 8192,
+		},
+		{
+			name:        "storage counters only",
+			content:     "read_bytes: 1\nwrite_bytes: 2",
+			wantRead:    1,
+			wantWritten: 2,
+		},
+		{
+			name:    "rchar and wchar aren't storage IO",
+			content: "rchar: 3980\nwchar: 12\n",
+		},
+		{
+			name:        "unparsable value",
+			content:     "read_bytes: lots\nwrite_bytes: 5\n",
+			wantWritten: 5,
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read, written := parseProcIO(tt.content)
+			if read != tt.wantRead || written != tt.wantWritten {
+				t.Errorf("parseProcIO = %d, %d, want %d, %d", read, written, tt.wantRead, tt.wantWritten)
+			}
+		})
+	}
+}
+
+func TestReadProcIO(t *testing.T) {
+	if _, err := os.Stat("/proc/self/io"); err != nil {
+		t.Skip("no /proc/<pid>/io:", err)
+	}
+	if _, _, ok := readProcIO(strconv.Itoa(os.Getpid())); !ok {
+		t.Error("can't read this process's IO")
+	}
+	if _, _, ok := readProcIO("0"); ok {
+		t.Error("read the IO of pid 0")
+	}
+}
@@ -0,0 +1,156 @@
+// process_window.go
+// accumulates every process sample between two sends, so the daily processes
+// summary covers the whole day and not just the last sample
+
+package events
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// maxWindowProcesses bounds how many processes a window tracks. Only processes that
+// made a top list are tracked, on a busy build host short lived compilers could
+// otherwise add thousands a day.
+const maxWindowProcesses = 256
+
+// ProcessAggregate is one process's resource use across the window
+type ProcessAggregate struct {
+	PID               int32     `json:"pid"`
+	Name              string    `json:"name"`
+	Username          string    `json:"username"`
+	PeakCPUPercent    float64   `json:"peak_cpu_percent"`
+	AvgCPUPercent     float64   `json:"avg_cpu_percent"` // while tracked, from the sample it first made a top list
+	PeakRSS_KB        uint64    `json:"peak_rss_kb"`
+	TopCPUSeconds     float64   `json:"top_cpu_seconds"` // time spent in the top N by CPU
+	TopMemSeconds     float64   `json:"top_mem_seconds"`
+	FirstSeen         time.Time `json:"first_seen"` // the sample it first made a top list
+	LastSeen          time.Time `json:"last_seen"`  // the latest sample it was running in
+	cpuPercentSeconds float64   // CPU percent integrated over the tracked time
+	trackedSeconds    float64
+}
+
+// ProcessSummary is sent as the processes_summary event
+type ProcessSummary struct {
+	WindowStart time.Time          `json:"window_start"`
+	WindowEnd   time.Time          `json:"window_end"`
+	Samples     int                `json:"samples"`
+	CPU         []ProcessAggregate `json:"cpu"` // by peak CPU
+	Mem         []ProcessAggregate `json:"mem"` // by peak RSS
+}
+
+// processKey identifies a process across samples, a reused pid has a new start time
+type processKey struct {
+	pid       int32
+	startTime uint64
+}
+
+// processWindow holds the aggregates since the last send, guarded by processDataMutex
+type processWindow struct {
+	start     time.Time
+	end       time.Time
+	samples   int
+	processes map[processKey]*ProcessAggregate
+}
+
+var window = processWindow{processes: make(map[processKey]*ProcessAggregate)}
+
+// add folds a sample into the window. Processes already tracked are updated whether
+// or not they're in a top list this time, so their averages cover the whole time tracked.
+func (w *processWindow) add(usage []processUsage, interval time.Duration, topCPU, topMem map[int32]bool) {
+	now := time.Now()
+	if w.samples == 0 {
+		w.start = now.Add(-interval)
+	}
+	w.end = now
+	w.samples++
+	seconds := interval.Seconds()
+
+	for _, u := range usage {
+		key := processKey{pid: u.pid, startTime: u.startTime}
+		agg, tracked := w.processes[key]
+		if !tracked {
+			if !topCPU[u.pid] && !topMem[u.pid] {
+				continue
+			}
+			agg = &ProcessAggregate{PID: u.pid, Name: u.name, Username: processUser(u.pid), FirstSeen: now}
+			w.processes[key] = agg
+		}
+		agg.LastSeen = now
+		agg.PeakCPUPercent = math.Max(agg.PeakCPUPercent, u.cpuPercent)
+		agg.PeakRSS_KB = max(agg.PeakRSS_KB, u.rssKB)
+		agg.cpuPercentSeconds += u.cpuPercent * seconds
+		agg.trackedSeconds += seconds
+		if topCPU[u.pid] {
+			agg.TopCPUSeconds += seconds
+		}
+		if topMem[u.pid] {
+			agg.TopMemSeconds += seconds
+		}
+	}
+	w.evict(now)
+}
+
+// evict drops the processes that spent the least time in a top list until the window
+// is back within maxWindowProcesses. Those seen in this sample are kept.
+func (w *processWindow) evict(now time.Time) {
+	excess := len(w.processes) - maxWindowProcesses
+	if excess <= 0 {
+		return
+	}
+	candidates := make([]processKey, 0, len(w.processes))
+	for key, agg := range w.processes {
+		if agg.LastSeen.Before(now) {
+			candidates = append(candidates, key)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := w.processes[candidates[i]], w.processes[candidates[j]]
+		if a.TopCPUSeconds+a.TopMemSeconds != b.TopCPUSeconds+b.TopMemSeconds {
+			return a.TopCPUSeconds+a.TopMemSeconds < b.TopCPUSeconds+b.TopMemSeconds
+		}
+		return a.LastSeen.Before(b.LastSeen)
+	})
+	for _, key := range candidates[:min(excess, len(candidates))] {
+		delete(w.processes, key)
+	}
+}
+
+// summary returns the topN processes by peak CPU and by peak RSS
+func (w *processWindow) summary(topN int) ProcessSummary {
+	summary := ProcessSummary{WindowStart: w.start, WindowEnd: w.end, Samples: w.samples}
+	all := make([]ProcessAggregate, 0, len(w.processes))
+	for _, agg := range w.processes {
+		a := *agg
+		if a.trackedSeconds > 0 {
+			a.AvgCPUPercent = math.Round(a.cpuPercentSeconds/a.trackedSeconds*10) / 10
+		}
+		a.PeakCPUPercent = math.Round(a.PeakCPUPercent*10) / 10
+		a.TopCPUSeconds = math.Round(a.TopCPUSeconds)
+		a.TopMemSeconds = math.Round(a.TopMemSeconds)
+		all = append(all, a)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].PeakCPUPercent != all[j].PeakCPUPercent {
+			return all[i].PeakCPUPercent > all[j].PeakCPUPercent
+		}
+		return all[i].PID < all[j].PID
+	})
+	summary.CPU = append([]ProcessAggregate(nil), all[:min(topN, len(all))]...)
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].PeakRSS_KB != all[j].PeakRSS_KB {
+			return all[i].PeakRSS_KB > all[j].PeakRSS_KB
+		}
+		return all[i].PID < all[j].PID
+	})
+	summary.Mem = append([]ProcessAggregate(nil), all[:min(topN, len(all))]...)
+	return summary
+}
+
+// reset starts a new window
+func (w *processWindow) reset() {
+	*w = processWindow{processes: make(map[processKey]*ProcessAggregate)}
+}
@@ -0,0 +1,117 @@
+// graphite.go
+// writes metrics to a Carbon plaintext listener, e.g. monkey.web01.load.load1 0.42 1700000000
+
+package exporters
+
+import (
+	"fmt"
+	"go_monitor/payload"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variable enabling the Graphite sink, host:port of a Carbon plaintext (TCP) listener
+const GraphiteAddrEnvVar = "MONKEY_GRAPHITE_ADDR"
+
+// Root of every Graphite and StatsD metric path, followed by the hostname
+const pathPrefix = "monkey"
+
+// Graphite writes collection cycles and numeric custom alerts over a short lived TCP connection
+type Graphite struct {
+	addr   string
+	prefix string
+}
+
+// NewGraphiteFromEnv returns a sink for MONKEY_GRAPHITE_ADDR, or nil if it isn't set
+func NewGraphiteFromEnv(host HostInfo) (*Graphite, error) {
+	addr := os.Getenv(GraphiteAddrEnvVar)
+	if addr == "" {
+		return nil, nil
+	}
+	return NewGraphite(addr, host)
+}
+
+// NewGraphite creates a sink writing to the Carbon listener at addr
+func NewGraphite(addr string, host HostInfo) (*Graphite, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", GraphiteAddrEnvVar, addr, err)
+	}
+	return &Graphite{addr: addr, prefix: hostPath(host)}, nil
+}
+
+// Name identifies the sink
+func (g *Graphite) Name() string {
+	return "graphite"
+}
+
+// Send writes every sample from the record, other records are ignored
+func (g *Graphite) Send(rec *payload.Record) error {
+	points := recordPoints(rec)
+	if len(points) == 0 {
+		return nil
+	}
+
+	timestamp := strconv.FormatInt(rec.Time.Unix(), 10)
+	var b strings.Builder
+	for _, p := range points {
+		if math.IsNaN(p.value) || math.IsInf(p.value, 0) {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", g.prefix+"."+metricPath(p), strconv.FormatFloat(p.value, 'f', -1, 64), timestamp)
+	}
+
+	conn, err := net.DialTimeout("tcp", g.addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Graphite: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return fmt.Errorf("failed to write to Graphite: %w", err)
+	}
+	return nil
+}
+
+// hostPath is the metric path prefix for this host, e.g. monkey.web01_2eexample_2ecom
+func hostPath(host HostInfo) string {
+	return pathPrefix + "." + pathComponent(host.Hostname)
+}
+
+// metricPath renders a sample as measurement[.tag values].field, e.g. disk.__home.used_percent.
+// Measurements and fields are the agent's own names, only tag values need escaping.
+func metricPath(p point) string {
+	parts := []string{p.measurement}
+	for n := 1; n < len(p.tags); n += 2 {
+		parts = append(parts, pathComponent(p.tags[n]))
+	}
+	parts = append(parts, p.field)
+	return strings.Join(parts, ".")
+}
+
+// pathComponent makes a value safe for a dotted metric path. Letters, digits and -
+// are kept, / becomes __ and anything else _ and its hex code, so /var/log is
+// __var__log, /var_log __var_5flog and an empty value _. Each value gets its own
+// path, no two mountpoints can end up writing to the same metric.
+func pathComponent(s string) string {
+	if s == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			b.WriteByte(c)
+		case c == '/':
+			b.WriteString("__")
+		default:
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
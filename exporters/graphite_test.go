@@ -0,0 +1,52 @@
+package exporters
+
+import "testing"
+
+func TestPathComponent(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"eth0", "eth0"},
+		{"nginx.service", "nginx_2eservice"},
+		{"web-01", "web-01"},
+		{"web01.example.com", "web01_2eexample_2ecom"},
+		{"/", "__"},
+		{"/root", "__root"},
+		{"/var/log", "__var__log"},
+		{"/var_log", "__var_5flog"},
+		{"", "_"},
+		{"é", "_c3_a9"},
+	}
+	for _, tt := range tests {
+		if got := pathComponent(tt.in); got != tt.want {
+			t.Errorf("pathComponent(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// values that used to share a path
+	seen := make(map[string]string)
+	for _, in := range []string{"/", "/root", "root", "/var/log", "/var_log", "/var.log", "var/log", ""} {
+		out := pathComponent(in)
+		if other, ok := seen[out]; ok {
+			t.Errorf("pathComponent(%q) and pathComponent(%q) are both %q", in, other, out)
+		}
+		seen[out] = in
+	}
+}
+
+func TestMetricPath(t *testing.T) {
+	tests := []struct {
+		p    point
+		want string
+	}{
+		{point{"load", nil, "load1", 0.5}, "load.load1"},
+		{point{"disk", labels{"mountpoint", "/"}, "used_percent", 20}, "disk.__.used_percent"},
+		{point{"disk", labels{"mountpoint", "/home"}, "used_percent", 20}, "disk.__home.used_percent"},
+		{point{"custom_alert", labels{"name", "queue depth"}, "value", 3}, "custom_alert.queue_20depth.value"},
+	}
+	for _, tt := range tests {
+		if got := metricPath(tt.p); got != tt.want {
+			t.Errorf("metricPath(%v) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
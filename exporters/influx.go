@@ -0,0 +1,167 @@
+// influx.go
+// writes metrics in the InfluxDB line protocol over HTTP or UDP
+
+package exporters
+
+import (
+	"fmt"
+	"go_monitor/payload"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables configuring the InfluxDB sink
+const (
+	InfluxURLEnvVar   = "MONKEY_INFLUX_URL"   // write URL, e.g. http://localhost:8086/write?db=monkey or udp://localhost:8089
+	InfluxTokenEnvVar = "MONKEY_INFLUX_TOKEN" // optional, sent as "Authorization: Token <token>"
+)
+
+// Influx writes collection cycles and numeric custom alerts as line protocol
+type Influx struct {
+	url    string // HTTP write URL, empty when writing over UDP
+	token  string
+	client *http.Client
+	conn   net.Conn
+	tags   string // escaped host tags, each with a leading comma
+}
+
+// NewInfluxFromEnv returns a sink for MONKEY_INFLUX_URL, or nil if it isn't set
+func NewInfluxFromEnv(host HostInfo) (*Influx, error) {
+	rawURL := os.Getenv(InfluxURLEnvVar)
+	if rawURL == "" {
+		return nil, nil
+	}
+	return NewInflux(rawURL, os.Getenv(InfluxTokenEnvVar), host)
+}
+
+// NewInflux creates a sink writing to an http(s):// write endpoint or a udp:// listener
+func NewInflux(rawURL, token string, host HostInfo) (*Influx, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", InfluxURLEnvVar, rawURL, err)
+	}
+
+	i := &Influx{token: token, tags: influxTags(hostTags(host))}
+	switch u.Scheme {
+	case "http", "https":
+		i.url = rawURL
+		i.client = &http.Client{Timeout: 10 * time.Second}
+	case "udp":
+		i.conn, err = net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open UDP socket to %s: %w", u.Host, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid %s %q (want http, https or udp)", InfluxURLEnvVar, rawURL)
+	}
+	return i, nil
+}
+
+// Name identifies the sink
+func (i *Influx) Name() string {
+	return "influx"
+}
+
+// Send writes one line per measurement and tag set, other records are ignored
+func (i *Influx) Send(rec *payload.Record) error {
+	lines := i.lines(recordPoints(rec), rec.Time)
+	if len(lines) == 0 {
+		return nil
+	}
+	if i.conn != nil {
+		return writeDatagrams(i.conn, lines)
+	}
+	return i.post(lines)
+}
+
+// Close releases the UDP socket
+func (i *Influx) Close() error {
+	if i.conn != nil {
+		return i.conn.Close()
+	}
+	return nil
+}
+
+// lines groups fields sharing a measurement and tags into single lines, keeping their order
+func (i *Influx) lines(points []point, t time.Time) []string {
+	var keys []string
+	fields := make(map[string][]string)
+	for _, p := range points {
+		if math.IsNaN(p.value) || math.IsInf(p.value, 0) {
+			continue // not representable in line protocol
+		}
+		key := escapeInflux(p.measurement, ", ") + i.tags + influxTags(p.tags)
+		if _, ok := fields[key]; !ok {
+			keys = append(keys, key)
+		}
+		fields[key] = append(fields[key], escapeInflux(p.field, ",= ")+"="+strconv.FormatFloat(p.value, 'g', -1, 64))
+	}
+
+	timestamp := strconv.FormatInt(t.UnixNano(), 10)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+" "+strings.Join(fields[key], ",")+" "+timestamp)
+	}
+	return lines
+}
+
+// post sends lines to the HTTP write endpoint
+func (i *Influx) post(lines []string) error {
+	req, err := http.NewRequest("POST", i.url, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return fmt.Errorf("failed to create InfluxDB request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB rejected write with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// influxTags renders tags as ,key=value pairs, skipping empty values which Influx rejects
+func influxTags(l labels) string {
+	var b strings.Builder
+	for n := 0; n+1 < len(l); n += 2 {
+		if l[n+1] == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(escapeInflux(l[n], ",= "))
+		b.WriteByte('=')
+		b.WriteString(escapeInflux(l[n+1], ",= "))
+	}
+	return b.String()
+}
+
+// escapeInflux backslash escapes the given special characters
+func escapeInflux(s, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
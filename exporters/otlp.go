@@ -0,0 +1,414 @@
+// otlp.go
+// pushes metrics to an OpenTelemetry collector using OTLP/HTTP with JSON encoding
+
+package exporters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go_monitor/events"
+	"go_monitor/payload"
+	"go_monitor/status"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables configuring the OTLP exporter
+const (
+	OTLPEndpointEnvVar = "MONKEY_OTLP_ENDPOINT" // e.g. http://localhost:4318, /v1/metrics is added if missing
+	OTLPHeadersEnvVar  = "MONKEY_OTLP_HEADERS"  // extra request headers as key=value,key2=value2
+)
+
+// OTLP aggregation temporality for cumulative sums
+const cumulativeTemporality = 2
+
+// HostInfo describes the host, sent as OTLP resource attributes
+type HostInfo struct {
+	Hostid   string
+	Hostname string
+	Os       string
+	Platform string
+	Ip       string
+	AgentVer string
+}
+
+// OTLP sends metrics to an OTLP/HTTP endpoint
+type OTLP struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+	resource otlpResource
+
+	// start times of the cumulative sums, fixed so backends don't see a reset
+	bootOnce sync.Once
+	boot     time.Time // host boot, for counters kept by the kernel
+	started  time.Time // agent start, for counters kept by the agent
+
+	// the network totals add up every counted interface, they drop when one goes
+	// away or its counters reset and the sum then needs a new start time
+	netMutex sync.Mutex
+	transmit resettableTotal
+	receive  resettableTotal
+}
+
+// resettableTotal tracks the start time of a cumulative total that can go down
+type resettableTotal struct {
+	start time.Time
+	last  uint64
+	at    time.Time
+}
+
+// startFor returns the start time for value read at now, moved to the previous
+// reading's time when value is below it so backends see a reset, not a negative rate
+func (r *resettableTotal) startFor(boot, now time.Time, value uint64) time.Time {
+	if r.start.IsZero() {
+		r.start = boot
+	} else if value < r.last {
+		r.start = r.at
+	}
+	r.last, r.at = value, now
+	return r.start
+}
+
+// NewOTLPFromEnv returns an exporter for MONKEY_OTLP_ENDPOINT, or nil if it isn't set
+func NewOTLPFromEnv(host HostInfo) (*OTLP, error) {
+	endpoint := os.Getenv(OTLPEndpointEnvVar)
+	if endpoint == "" {
+		return nil, nil
+	}
+	headers, err := parseHeaders(os.Getenv(OTLPHeadersEnvVar))
+	if err != nil {
+		return nil, err
+	}
+	return NewOTLP(endpoint, headers, host), nil
+}
+
+// NewOTLP creates an exporter posting to endpoint
+func NewOTLP(endpoint string, headers map[string]string, host HostInfo) *OTLP {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/metrics") {
+		endpoint += "/v1/metrics"
+	}
+	return &OTLP{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		resource: otlpResource{Attributes: []otlpAttribute{
+			stringAttr("service.name", "monitor-monkey-agent"),
+			stringAttr("service.version", host.AgentVer),
+			stringAttr("host.id", host.Hostid),
+			stringAttr("host.name", host.Hostname),
+			stringAttr("host.ip", host.Ip),
+			stringAttr("os.type", host.Os),
+			stringAttr("os.description", host.Platform),
+		}},
+		started: status.Snapshot().Started,
+	}
+}
+
+// parseHeaders splits key=value,key2=value2 into a map
+func parseHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid %s entry %q (want key=value)", OTLPHeadersEnvVar, pair)
+		}
+		headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return headers, nil
+}
+
+// Name identifies the sink
+func (o *OTLP) Name() string {
+	return "otlp"
+}
+
+// Send exports collection cycles, process samples and custom alerts, events are ignored
+func (o *OTLP) Send(rec *payload.Record) error {
+	switch rec.Kind {
+	case payload.KindMesure:
+		return o.ExportMesure(rec)
+	case payload.KindProcesses:
+		return o.ExportProcesses(rec.Processes.CPU, rec.Processes.Mem)
+	case payload.KindCustomAlert:
+		return o.ExportCustomAlert(rec.Alert.Name, rec.Alert.Value)
+	}
+	return nil
+}
+
+// ExportMesure sends the host metrics from a collection cycle, leaving out the groups
+// the sink's filter removed so zeroed fields aren't sent as readings or counter resets
+func (o *OTLP) ExportMesure(rec *payload.Record) error {
+	m := rec.Mesure
+	now := time.Unix(m.Heartbeat, 0)
+	// Uptime is whole seconds, working boot out again every cycle would make it jitter
+	o.bootOnce.Do(func() {
+		o.boot = now.Add(-time.Duration(m.Uptime) * time.Second)
+	})
+	boot := o.boot
+
+	var metrics []otlpMetric
+	for _, period := range []string{"1m", "5m", "15m"} {
+		if value, ok := m.Load["load"+strings.TrimSuffix(period, "m")]; ok {
+			metrics = append(metrics, gauge("system.cpu.load_average."+period, "{thread}", doublePoint(now, value)))
+		}
+	}
+	if m.IOWait != nil {
+		metrics = append(metrics, gauge("system.cpu.iowait.utilization", "1", doublePoint(now, *m.IOWait/100)))
+	}
+	if rec.Includes("memory") {
+		metrics = append(metrics, gauge("system.memory.utilization", "1", doublePoint(now, m.Memory/100)))
+	}
+	metrics = append(metrics, gauge("system.uptime", "s", intPoint(now, int64(m.Uptime))))
+	if rec.Includes("network") {
+		o.netMutex.Lock()
+		transmitStart := o.transmit.startFor(boot, now, m.Upload)
+		receiveStart := o.receive.startFor(boot, now, m.Download)
+		o.netMutex.Unlock()
+		metrics = append(metrics, sum("system.network.io", "By",
+			cumulativePoint(transmitStart, now, int64(m.Upload), stringAttr("network.io.direction", "transmit")),
+			cumulativePoint(receiveStart, now, int64(m.Download), stringAttr("network.io.direction", "receive")),
+		))
+	}
+
+	if len(m.Disks) > 0 {
+		var points []otlpDataPoint
+		for _, mountpoint := range sortedKeys(m.Disks) {
+			points = append(points, doublePoint(now, m.Disks[mountpoint]/100, stringAttr("system.filesystem.mountpoint", mountpoint)))
+		}
+		metrics = append(metrics, gauge("system.filesystem.utilization", "1", points...))
+	}
+
+	if len(m.Temp) > 0 {
+		var points []otlpDataPoint
+		for _, reading := range m.Temp {
+			points = append(points, doublePoint(now, reading.Temperature, stringAttr("sensor", reading.SensorKey)))
+		}
+		metrics = append(metrics, gauge("hw.temperature", "Cel", points...))
+	}
+
+	if len(m.Services) > 0 {
+		var active, states []otlpDataPoint
+		for _, service := range sortedKeys(m.Services) {
+			state := m.Services[service]
+			value := int64(0)
+			if state == "active" {
+				value = 1
+			}
+			active = append(active, intPoint(now, value, stringAttr("service", service)))
+			states = append(states, intPoint(now, 1, stringAttr("service", service), stringAttr("state", state)))
+		}
+		metrics = append(metrics,
+			gauge("monkey.service.active", "1", active...),
+			gauge("monkey.service.state", "1", states...),
+		)
+	}
+
+	if a := m.Agent; a != nil {
+		metrics = append(metrics,
+			gauge("monkey.agent.memory.rss", "By", intPoint(now, int64(a.RSSBytes))),
+			gauge("monkey.agent.memory.heap", "By", intPoint(now, int64(a.HeapAllocBytes))),
+			gauge("monkey.agent.goroutines", "{goroutine}", intPoint(now, int64(a.Goroutines))),
+			gauge("monkey.agent.open_fds", "{file}", intPoint(now, int64(a.OpenFDs))),
+			gauge("monkey.agent.cycle.duration", "ms", doublePoint(now, a.CycleMs)),
+			gauge("monkey.agent.send.latency", "ms", doublePoint(now, a.SendLatencyMs)),
+			sum("monkey.agent.errors", "{error}",
+				cumulativePoint(o.started, now, int64(a.CollectorErrors), stringAttr("error.source", "collector")),
+				cumulativePoint(o.started, now, int64(a.SendErrors), stringAttr("error.source", "send")),
+				cumulativePoint(o.started, now, int64(a.SinkFailures), stringAttr("error.source", "sink")),
+			),
+		)
+	}
+
+	return o.export(metrics)
+}
+
+// ExportCustomAlert sends a numeric custom alert value, other values are skipped
+func (o *OTLP) ExportCustomAlert(name string, value interface{}) error {
+	v, ok := numericValue(value)
+	if !ok {
+		return nil
+	}
+	return o.export([]otlpMetric{
+		gauge("monkey.custom_alert.value", "1", doublePoint(time.Now(), v, stringAttr("alert.name", name))),
+	})
+}
+
+// ExportProcesses sends the CPU and memory usage of the top processes
+func (o *OTLP) ExportProcesses(cpuStats []events.ProcessCPUStat, memStats []events.ProcessMemStat) error {
+	now := time.Now()
+	var metrics []otlpMetric
+
+	if len(cpuStats) > 0 {
+		var points []otlpDataPoint
+		for _, p := range cpuStats {
+			points = append(points, doublePoint(now, p.CPUPercent/100, processAttrs(p.PID, p.Name, p.Username)...))
+		}
+		metrics = append(metrics, gauge("process.cpu.utilization", "1", points...))
+	}
+	if len(memStats) > 0 {
+		var points []otlpDataPoint
+		for _, p := range memStats {
+			points = append(points, intPoint(now, int64(p.RSS_KB)*1024, processAttrs(p.PID, p.Name, p.Username)...))
+		}
+		metrics = append(metrics, gauge("process.memory.usage", "By", points...))
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return o.export(metrics)
+}
+
+func processAttrs(pid int32, name, user string) []otlpAttribute {
+	return []otlpAttribute{
+		intAttr("process.pid", int64(pid)),
+		stringAttr("process.executable.name", name),
+		stringAttr("process.owner", user),
+	}
+}
+
+// export wraps metrics in a request for this host and posts it
+func (o *OTLP) export(metrics []otlpMetric) error {
+	request := otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: o.resource,
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "monitor-monkey-agent"},
+			Metrics: metrics,
+		}},
+	}}}
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal OTLP request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", o.endpoint, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range o.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send OTLP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OTLP endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// OTLP/JSON request structure, see opentelemetry-proto metrics/v1
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Unit  string     `json:"unit,omitempty"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+// otlpDataPoint is a NumberDataPoint, 64 bit integers are strings in OTLP/JSON
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+	AsInt             string          `json:"asInt,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+}
+
+func gauge(name, unit string, points ...otlpDataPoint) otlpMetric {
+	return otlpMetric{Name: name, Unit: unit, Gauge: &otlpGauge{DataPoints: points}}
+}
+
+func sum(name, unit string, points ...otlpDataPoint) otlpMetric {
+	return otlpMetric{Name: name, Unit: unit, Sum: &otlpSum{
+		DataPoints:             points,
+		AggregationTemporality: cumulativeTemporality,
+		IsMonotonic:            true,
+	}}
+}
+
+func doublePoint(t time.Time, value float64, attrs ...otlpAttribute) otlpDataPoint {
+	return otlpDataPoint{Attributes: attrs, TimeUnixNano: unixNano(t), AsDouble: &value}
+}
+
+func intPoint(t time.Time, value int64, attrs ...otlpAttribute) otlpDataPoint {
+	return otlpDataPoint{Attributes: attrs, TimeUnixNano: unixNano(t), AsInt: strconv.FormatInt(value, 10)}
+}
+
+func cumulativePoint(start, t time.Time, value int64, attrs ...otlpAttribute) otlpDataPoint {
+	p := intPoint(t, value, attrs...)
+	p.StartTimeUnixNano = unixNano(start)
+	return p
+}
+
+func stringAttr(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int64) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: strconv.FormatInt(value, 10)}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
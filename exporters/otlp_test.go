@@ -0,0 +1,30 @@
+package exporters
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResettableTotalStartFor(t *testing.T) {
+	boot := time.Unix(1700000000, 0)
+	cycle := func(n int) time.Time { return boot.Add(time.Hour + time.Duration(n)*time.Minute) }
+
+	var total resettableTotal
+	readings := []struct {
+		name      string
+		value     uint64
+		wantStart time.Time
+	}{
+		{"first reading starts at boot", 1000, boot},
+		{"growing", 5000, boot},
+		{"unchanged", 5000, boot},
+		{"interface gone", 3000, cycle(2)},
+		{"growing after the reset", 4000, cycle(2)},
+		{"counter reset", 10, cycle(4)},
+	}
+	for i, r := range readings {
+		if got := total.startFor(boot, cycle(i), r.value); !got.Equal(r.wantStart) {
+			t.Errorf("%s: start = %v, want %v", r.name, got, r.wantStart)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// points.go
+// flattens records into simple numeric samples for the line based sinks
+// (InfluxDB, StatsD and Graphite) and the helpers they share
+
+package exporters
+
+import (
+	"fmt"
+	"go_monitor/monitors"
+	"go_monitor/payload"
+	"net"
+	"sort"
+	"strings"
+)
+
+// maxDatagramSize keeps each UDP write within a typical MTU
+const maxDatagramSize = 1400
+
+// point is one numeric sample, e.g. measurement "disk", tags mountpoint=/, field "used_percent"
+type point struct {
+	measurement string
+	tags        labels
+	field       string
+	value       float64
+}
+
+// recordPoints returns the samples for a collection cycle or a numeric custom alert,
+// other records produce none
+func recordPoints(rec *payload.Record) []point {
+	switch rec.Kind {
+	case payload.KindMesure:
+		return mesurePoints(rec)
+	case payload.KindCustomAlert:
+		if v, ok := numericValue(rec.Alert.Value); ok {
+			return []point{{"custom_alert", labels{"name", rec.Alert.Name}, "value", v}}
+		}
+	}
+	return nil
+}
+
+// mesurePoints lists every metric in a collection cycle the sink's filter left in
+func mesurePoints(rec *payload.Record) []point {
+	m := rec.Mesure
+	points := []point{{"system", nil, "uptime_seconds", float64(m.Uptime)}}
+
+	for _, period := range []string{"load1", "load5", "load15"} {
+		if value, ok := m.Load[period]; ok {
+			points = append(points, point{"load", nil, period, value})
+		}
+	}
+
+	if m.IOWait != nil {
+		points = append(points, point{"cpu", nil, "iowait_percent", *m.IOWait})
+	}
+
+	if rec.Includes("memory") {
+		points = append(points, point{"memory", nil, "used_percent", m.Memory})
+	}
+
+	for _, mountpoint := range sortedKeys(m.Disks) {
+		points = append(points, point{"disk", labels{"mountpoint", mountpoint}, "used_percent", m.Disks[mountpoint]})
+	}
+
+	if rec.Includes("network") {
+		points = append(points,
+			point{"net", nil, "bytes_sent", float64(m.Upload)},
+			point{"net", nil, "bytes_recv", float64(m.Download)},
+			point{"net", nil, "bytes_sent_interval", float64(m.UploadInterval)},
+			point{"net", nil, "bytes_recv_interval", float64(m.DownloadInterval)},
+			point{"net", nil, "bytes_sent_per_sec", m.UploadRate},
+			point{"net", nil, "bytes_recv_per_sec", m.DownloadRate},
+			point{"net", nil, "packets_sent_per_sec", m.PacketsSentRate},
+			point{"net", nil, "packets_recv_per_sec", m.PacketsRecvRate},
+		)
+	}
+
+	if s := m.NetStack; s != nil {
+		for _, c := range []struct {
+			name    string
+			counter monitors.StackCounter
+		}{
+			{"tcp_retrans_segs", s.TCPRetransSegs},
+			{"tcp_out_rsts", s.TCPOutRsts},
+			{"tcp_estab_resets", s.TCPEstabResets},
+			{"tcp_attempt_fails", s.TCPAttemptFails},
+			{"tcp_in_errs", s.TCPInErrs},
+			{"tcp_listen_overflows", s.TCPListenOverflows},
+			{"tcp_listen_drops", s.TCPListenDrops},
+			{"udp_in_errors", s.UDPInErrors},
+			{"udp_rcvbuf_errors", s.UDPRcvbufErrors},
+			{"udp_sndbuf_errors", s.UDPSndbufErrors},
+			{"udp_no_ports", s.UDPNoPorts},
+		} {
+			points = append(points,
+				point{"netstack", nil, c.name, float64(c.counter.Delta)},
+				point{"netstack", nil, c.name + "_per_sec", c.counter.Rate},
+			)
+		}
+		points = append(points,
+			point{"netstack", nil, "tcp_retrans_percent", s.TCPRetransPercent},
+			point{"netstack", nil, "sockets_used", float64(s.SocketsUsed)},
+			point{"netstack", nil, "tcp_established", float64(s.TCPEstablished)},
+			point{"netstack", nil, "tcp_inuse", float64(s.TCPInUse)},
+			point{"netstack", nil, "tcp_orphan", float64(s.TCPOrphan)},
+			point{"netstack", nil, "tcp_time_wait", float64(s.TCPTimeWait)},
+			point{"netstack", nil, "udp_inuse", float64(s.UDPInUse)},
+		)
+	}
+
+	for _, name := range sortedKeys(m.Interfaces) {
+		iface := m.Interfaces[name]
+		tags := labels{"interface", name}
+		points = append(points,
+			point{"net_interface", tags, "bytes_sent_per_sec", iface.UploadRate},
+			point{"net_interface", tags, "bytes_recv_per_sec", iface.DownloadRate},
+			point{"net_interface", tags, "packets_sent_per_sec", iface.PacketsSentRate},
+			point{"net_interface", tags, "packets_recv_per_sec", iface.PacketsRecvRate},
+			point{"net_interface", tags, "errors_in", float64(iface.ErrorsIn)},
+			point{"net_interface", tags, "errors_out", float64(iface.ErrorsOut)},
+			point{"net_interface", tags, "drops_in", float64(iface.DropsIn)},
+			point{"net_interface", tags, "drops_out", float64(iface.DropsOut)},
+		)
+	}
+
+	for _, reading := range m.Temp {
+		points = append(points, point{"temperature", labels{"sensor", reading.SensorKey}, "celsius", reading.Temperature})
+	}
+
+	services := make([]string, 0, len(m.Services))
+	for service := range m.Services {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		active := 0.0
+		if m.Services[service] == "active" {
+			active = 1
+		}
+		points = append(points, point{"service", labels{"service", service}, "active", active})
+	}
+
+	if a := m.Agent; a != nil {
+		points = append(points,
+			point{"agent", nil, "rss_bytes", float64(a.RSSBytes)},
+			point{"agent", nil, "heap_bytes", float64(a.HeapAllocBytes)},
+			point{"agent", nil, "goroutines", float64(a.Goroutines)},
+			point{"agent", nil, "open_fds", float64(a.OpenFDs)},
+			point{"agent", nil, "cycle_ms", a.CycleMs},
+			point{"agent", nil, "send_latency_ms", a.SendLatencyMs},
+			point{"agent", nil, "collector_errors", float64(a.CollectorErrors)},
+			point{"agent", nil, "send_errors", float64(a.SendErrors)},
+			point{"agent", nil, "sink_failures", float64(a.SinkFailures)},
+			point{"agent", nil, "sink_dropped", float64(a.SinkDropped)},
+		)
+	}
+
+	return points
+}
+
+// numericValue converts a custom alert value to a number, false for strings and the like
+func numericValue(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// hostTags identifies the host on every sample
+func hostTags(host HostInfo) labels {
+	return labels{
+		"host", host.Hostname,
+		"hostid", host.Hostid,
+		"os", host.Os,
+		"platform", host.Platform,
+	}
+}
+
+// writeDatagrams sends lines over a UDP connection, packing as many as fit in each datagram
+func writeDatagrams(conn net.Conn, lines []string) error {
+	var packet strings.Builder
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write([]byte(packet.String()))
+		packet.Reset()
+		if err != nil {
+			return fmt.Errorf("failed to write datagram: %w", err)
+		}
+		return nil
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > maxDatagramSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		packet.WriteString(line)
+		packet.WriteByte('\n')
+	}
+	return flush()
+}
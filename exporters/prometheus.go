@@ -0,0 +1,359 @@
+// prometheus.go
+// exposes the latest collection cycle in the Prometheus text format
+
+package exporters
+
+import (
+	"bytes"
+	"fmt"
+	"go_monitor/logger"
+	"go_monitor/monitors"
+	"go_monitor/payload"
+	"go_monitor/status"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var log = logger.For("exporters")
+
+// Environment variable enabling the /metrics listener, e.g. :9188
+const PrometheusAddrEnvVar = "MONKEY_PROMETHEUS_ADDR"
+
+// Prefix for every metric name
+const metricPrefix = "monkey_"
+
+// Prometheus keeps the most recent measurement and renders it on scrape
+type Prometheus struct {
+	mutex  sync.Mutex
+	latest *payload.Record
+}
+
+// NewPrometheus creates an exporter with nothing collected yet
+func NewPrometheus() *Prometheus {
+	return &Prometheus{}
+}
+
+// Name identifies the sink
+func (p *Prometheus) Name() string {
+	return "prometheus"
+}
+
+// Send keeps each collection cycle for the next scrape, other records are ignored
+func (p *Prometheus) Send(rec *payload.Record) error {
+	if rec.Kind == payload.KindMesure {
+		p.Update(rec)
+	}
+	return nil
+}
+
+// Update stores the measurement record from the latest collection cycle
+func (p *Prometheus) Update(rec *payload.Record) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.latest = rec
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	rec := p.latest
+	p.mutex.Unlock()
+
+	var buf bytes.Buffer
+	if rec != nil {
+		writeMesure(&buf, rec)
+	}
+	writeAgentStatus(&buf, status.Snapshot())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// writeMesure renders the host metrics from a collection cycle, leaving out the
+// groups the sink's filter removed rather than rendering them as zeros
+func writeMesure(buf *bytes.Buffer, rec *payload.Record) {
+	m := rec.Mesure
+	writeHeader(buf, "agent_info", "gauge", "Agent and host details, always 1")
+	writeSample(buf, "agent_info", labels{
+		"version", m.AgentVer,
+		"hostid", m.Hostid,
+		"hostname", m.Hostname,
+		"os", m.Os,
+		"platform", m.Platform,
+		"ip", m.Ip,
+	}, 1)
+
+	writeHeader(buf, "last_collection_timestamp_seconds", "gauge", "Unix time of the latest collection cycle")
+	writeSample(buf, "last_collection_timestamp_seconds", nil, float64(m.Heartbeat))
+
+	writeHeader(buf, "host_uptime_seconds", "gauge", "Host uptime")
+	writeSample(buf, "host_uptime_seconds", nil, float64(m.Uptime))
+
+	for _, period := range []string{"load1", "load5", "load15"} {
+		if value, ok := m.Load[period]; ok {
+			writeHeader(buf, period, "gauge", "Load average over "+strings.TrimPrefix(period, "load")+" minutes")
+			writeSample(buf, period, nil, value)
+		}
+	}
+
+	if m.IOWait != nil {
+		writeHeader(buf, "cpu_iowait_percent", "gauge", "CPU time spent waiting on IO since the previous cycle, as a percentage")
+		writeSample(buf, "cpu_iowait_percent", nil, *m.IOWait)
+	}
+
+	if rec.Includes("memory") {
+		writeHeader(buf, "memory_used_percent", "gauge", "Memory in use as a percentage of total")
+		writeSample(buf, "memory_used_percent", nil, m.Memory)
+	}
+
+	if len(m.Disks) > 0 {
+		writeHeader(buf, "disk_used_percent", "gauge", "Disk space in use as a percentage of total")
+		for _, mountpoint := range sortedKeys(m.Disks) {
+			writeSample(buf, "disk_used_percent", labels{"mountpoint", mountpoint}, m.Disks[mountpoint])
+		}
+	}
+
+	if rec.Includes("network") {
+		writeHeader(buf, "network_transmit_bytes_total", "counter", "Bytes sent on all monitored interfaces")
+		writeSample(buf, "network_transmit_bytes_total", nil, float64(m.Upload))
+		writeHeader(buf, "network_receive_bytes_total", "counter", "Bytes received on all monitored interfaces")
+		writeSample(buf, "network_receive_bytes_total", nil, float64(m.Download))
+		writeHeader(buf, "network_transmit_bytes_per_second", "gauge", "Bytes sent per second since the previous cycle")
+		writeSample(buf, "network_transmit_bytes_per_second", nil, m.UploadRate)
+		writeHeader(buf, "network_receive_bytes_per_second", "gauge", "Bytes received per second since the previous cycle")
+		writeSample(buf, "network_receive_bytes_per_second", nil, m.DownloadRate)
+		writeHeader(buf, "network_transmit_packets_per_second", "gauge", "Packets sent per second since the previous cycle")
+		writeSample(buf, "network_transmit_packets_per_second", nil, m.PacketsSentRate)
+		writeHeader(buf, "network_receive_packets_per_second", "gauge", "Packets received per second since the previous cycle")
+		writeSample(buf, "network_receive_packets_per_second", nil, m.PacketsRecvRate)
+	}
+
+	if len(m.Interfaces) > 0 {
+		names := sortedKeys(m.Interfaces)
+		for _, metric := range interfaceMetrics {
+			writeHeader(buf, metric.name, "gauge", metric.help)
+			for _, name := range names {
+				writeSample(buf, metric.name, labels{"interface", name}, metric.value(m.Interfaces[name]))
+			}
+		}
+	}
+
+	if len(m.Temp) > 0 {
+		writeHeader(buf, "temperature_celsius", "gauge", "Hardware sensor temperature")
+		for _, reading := range m.Temp {
+			writeSample(buf, "temperature_celsius", labels{"sensor", reading.SensorKey}, reading.Temperature)
+		}
+	}
+
+	if s := m.NetStack; s != nil {
+		for _, c := range []struct {
+			name, help string
+			counter    monitors.StackCounter
+		}{
+			{"tcp_retransmitted_segments_total", "TCP segments retransmitted", s.TCPRetransSegs},
+			{"tcp_sent_segments_total", "TCP segments sent", s.TCPOutSegs},
+			{"tcp_sent_resets_total", "TCP resets sent", s.TCPOutRsts},
+			{"tcp_established_resets_total", "Established TCP connections reset", s.TCPEstabResets},
+			{"tcp_attempt_fails_total", "TCP connection attempts that failed", s.TCPAttemptFails},
+			{"tcp_receive_errors_total", "Bad TCP segments received", s.TCPInErrs},
+			{"tcp_listen_overflows_total", "Connections refused because an accept queue was full", s.TCPListenOverflows},
+			{"tcp_listen_drops_total", "SYNs dropped on listening sockets", s.TCPListenDrops},
+			{"udp_receive_errors_total", "UDP datagrams that couldn't be delivered", s.UDPInErrors},
+			{"udp_receive_buffer_errors_total", "UDP datagrams dropped because a receive buffer was full", s.UDPRcvbufErrors},
+			{"udp_send_buffer_errors_total", "UDP datagrams dropped because a send buffer was full", s.UDPSndbufErrors},
+			{"udp_no_port_total", "UDP datagrams to a port nothing listens on", s.UDPNoPorts},
+		} {
+			writeHeader(buf, c.name, "counter", c.help)
+			writeSample(buf, c.name, nil, float64(c.counter.Total))
+		}
+		writeHeader(buf, "tcp_retransmit_percent", "gauge", "TCP segments retransmitted as a percentage of those sent since the previous cycle")
+		writeSample(buf, "tcp_retransmit_percent", nil, s.TCPRetransPercent)
+		writeHeader(buf, "sockets", "gauge", "Sockets by protocol and state")
+		writeSample(buf, "sockets", labels{"protocol", "tcp", "state", "established"}, float64(s.TCPEstablished))
+		writeSample(buf, "sockets", labels{"protocol", "tcp", "state", "inuse"}, float64(s.TCPInUse))
+		writeSample(buf, "sockets", labels{"protocol", "tcp", "state", "orphan"}, float64(s.TCPOrphan))
+		writeSample(buf, "sockets", labels{"protocol", "tcp", "state", "time_wait"}, float64(s.TCPTimeWait))
+		writeSample(buf, "sockets", labels{"protocol", "tcp", "state", "alloc"}, float64(s.TCPAlloc))
+		writeSample(buf, "sockets", labels{"protocol", "udp", "state", "inuse"}, float64(s.UDPInUse))
+	}
+
+	if len(m.Services) > 0 {
+		writeHeader(buf, "service_active", "gauge", "Whether the systemd service is active (1) or not (0)")
+		services := make([]string, 0, len(m.Services))
+		for service := range m.Services {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		for _, service := range services {
+			active := 0.0
+			if m.Services[service] == "active" {
+				active = 1
+			}
+			writeSample(buf, "service_active", labels{"service", service}, active)
+		}
+		// the state is an info metric, as a label on service_active every state change
+		// would start a new series
+		writeHeader(buf, "service_state", "gauge", "The systemd service's active state, always 1")
+		for _, service := range services {
+			writeSample(buf, "service_state", labels{"service", service, "state", m.Services[service]}, 1)
+		}
+	}
+
+	if a := m.Agent; a != nil {
+		writeHeader(buf, "agent_resident_memory_bytes", "gauge", "Agent resident set size")
+		writeSample(buf, "agent_resident_memory_bytes", nil, float64(a.RSSBytes))
+		writeHeader(buf, "agent_heap_bytes", "gauge", "Agent Go heap in use")
+		writeSample(buf, "agent_heap_bytes", nil, float64(a.HeapAllocBytes))
+		writeHeader(buf, "agent_goroutines", "gauge", "Agent goroutines")
+		writeSample(buf, "agent_goroutines", nil, float64(a.Goroutines))
+		writeHeader(buf, "agent_open_fds", "gauge", "Agent open file descriptors")
+		writeSample(buf, "agent_open_fds", nil, float64(a.OpenFDs))
+		writeHeader(buf, "agent_cycle_duration_seconds", "gauge", "How long the latest collection cycle took")
+		writeSample(buf, "agent_cycle_duration_seconds", nil, a.CycleMs/1000)
+		writeHeader(buf, "agent_send_latency_seconds", "gauge", "How long the latest update took to send")
+		writeSample(buf, "agent_send_latency_seconds", nil, a.SendLatencyMs/1000)
+		writeHeader(buf, "agent_send_errors_total", "counter", "Updates that failed to send")
+		writeSample(buf, "agent_send_errors_total", nil, float64(a.SendErrors))
+		writeHeader(buf, "agent_sink_dropped_total", "counter", "Records dropped because a sink fell behind")
+		writeSample(buf, "agent_sink_dropped_total", nil, float64(a.SinkDropped))
+	}
+}
+
+// writeAgentStatus renders the agent's own collector and send statistics
+func writeAgentStatus(buf *bytes.Buffer, s status.Status) {
+	writeHeader(buf, "agent_consecutive_send_failures", "gauge", "Updates that failed to send in a row")
+	writeSample(buf, "agent_consecutive_send_failures", nil, float64(s.ConsecutiveFailures))
+
+	if !s.LastSuccess.IsZero() {
+		writeHeader(buf, "agent_last_send_timestamp_seconds", "gauge", "Unix time of the last successful update")
+		writeSample(buf, "agent_last_send_timestamp_seconds", nil, float64(s.LastSuccess.Unix()))
+	}
+
+	if len(s.Collectors) == 0 {
+		return
+	}
+	names := make([]string, 0, len(s.Collectors))
+	for name := range s.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeHeader(buf, "collector_duration_seconds", "gauge", "How long the collector took on its last run")
+	for _, name := range names {
+		writeSample(buf, "collector_duration_seconds", labels{"collector", name}, s.Collectors[name].DurationMs/1000)
+	}
+	writeHeader(buf, "collector_errors_total", "counter", "Collector runs that returned an error")
+	for _, name := range names {
+		writeSample(buf, "collector_errors_total", labels{"collector", name}, float64(s.Collectors[name].Errors))
+	}
+}
+
+// labels is a flat list of name, value pairs kept in order
+type labels []string
+
+// interfaceMetrics are the per interface gauges, labelled with the interface name
+var interfaceMetrics = []struct {
+	name  string
+	help  string
+	value func(iface monitors.InterfaceStats) float64
+}{
+	{"interface_transmit_bytes_per_second", "Bytes sent per second on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return iface.UploadRate }},
+	{"interface_receive_bytes_per_second", "Bytes received per second on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return iface.DownloadRate }},
+	{"interface_transmit_packets_per_second", "Packets sent per second on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return iface.PacketsSentRate }},
+	{"interface_receive_packets_per_second", "Packets received per second on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return iface.PacketsRecvRate }},
+	{"interface_receive_errors", "Receive errors on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return float64(iface.ErrorsIn) }},
+	{"interface_transmit_errors", "Transmit errors on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return float64(iface.ErrorsOut) }},
+	{"interface_receive_drops", "Received packets dropped on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return float64(iface.DropsIn) }},
+	{"interface_transmit_drops", "Outgoing packets dropped on the interface since the previous cycle",
+		func(iface monitors.InterfaceStats) float64 { return float64(iface.DropsOut) }},
+}
+
+func writeHeader(buf *bytes.Buffer, name, metricType, help string) {
+	fmt.Fprintf(buf, "# HELP %s%s %s\n", metricPrefix, name, help)
+	fmt.Fprintf(buf, "# TYPE %s%s %s\n", metricPrefix, name, metricType)
+}
+
+func writeSample(buf *bytes.Buffer, name string, l labels, value float64) {
+	buf.WriteString(metricPrefix)
+	buf.WriteString(name)
+	if len(l) > 0 {
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(l); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(l[i])
+			buf.WriteString(`="`)
+			buf.WriteString(escapeLabelValue(l[i+1]))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatValue(value))
+	buf.WriteByte('\n')
+}
+
+// escapeLabelValue escapes backslashes, quotes and newlines as the text format requires
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return strings.ReplaceAll(v, "\n", `\n`)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ServePrometheus starts a listener on addr serving the exporter on /metrics
+func ServePrometheus(addr string, p *Prometheus) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	log.Info("Serving Prometheus metrics", "addr", listener.Addr().String())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", p)
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("Prometheus listener stopped", "err", err)
+		}
+	}()
+	return nil
+}
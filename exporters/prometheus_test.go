@@ -0,0 +1,78 @@
+package exporters
+
+import (
+	"bytes"
+	"go_monitor/payload"
+	"strings"
+	"testing"
+)
+
+func TestWriteMesureOmittedGroups(t *testing.T) {
+	m := &payload.Mesure{Heartbeat: 1700000000, Uptime: 60, Memory: 40, Upload: 1000, Download: 2000}
+	tests := []struct {
+		name    string
+		omitted map[string]bool
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "nothing omitted",
+			want: []string{"memory_used_percent 40", "network_transmit_bytes_total 1000", "host_uptime_seconds 60"},
+		},
+		{
+			name:    "memory and network filtered out",
+			omitted: map[string]bool{"memory": true, "network": true},
+			want:    []string{"host_uptime_seconds 60"},
+			notWant: []string{"memory_used_percent", "network_transmit_bytes_total", "network_receive_bytes_per_second"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := payload.NewMesureRecord(m)
+			rec.Omitted = tt.omitted
+			var buf bytes.Buffer
+			writeMesure(&buf, rec)
+			out := buf.String()
+			for _, metric := range tt.want {
+				if !strings.Contains(out, metricPrefix+metric) {
+					t.Errorf("missing %s in\n%s", metric, out)
+				}
+			}
+			for _, metric := range tt.notWant {
+				if strings.Contains(out, metricPrefix+metric) {
+					t.Errorf("filtered out %s still written", metric)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordPointsOmittedGroups(t *testing.T) {
+	rec := payload.NewMesureRecord(&payload.Mesure{Memory: 40, Upload: 1000})
+	rec.Omitted = map[string]bool{"memory": true, "network": true}
+	for _, p := range recordPoints(rec) {
+		if p.measurement == "memory" || p.measurement == "net" {
+			t.Errorf("filtered out %s.%s still written", p.measurement, p.field)
+		}
+	}
+}
+
+func TestWriteMesureServices(t *testing.T) {
+	m := &payload.Mesure{Heartbeat: 1700000000, Services: map[string]string{"nginx": "active", "redis": "failed"}}
+	var buf bytes.Buffer
+	writeMesure(&buf, payload.NewMesureRecord(m))
+	out := buf.String()
+	for _, sample := range []string{
+		`service_active{service="nginx"} 1`,
+		`service_active{service="redis"} 0`,
+		`service_state{service="nginx",state="active"} 1`,
+		`service_state{service="redis",state="failed"} 1`,
+	} {
+		if !strings.Contains(out, metricPrefix+sample+"\n") {
+			t.Errorf("missing %s in\n%s", sample, out)
+		}
+	}
+	if strings.Contains(out, `service_active{service="nginx",state=`) {
+		t.Error("service_active still has the state label")
+	}
+}
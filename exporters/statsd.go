@@ -0,0 +1,70 @@
+// statsd.go
+// sends metrics to a StatsD server as gauges over UDP
+
+package exporters
+
+import (
+	"fmt"
+	"go_monitor/payload"
+	"math"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Environment variable enabling the StatsD sink, host:port of a StatsD (UDP) server
+const StatsDAddrEnvVar = "MONKEY_STATSD_ADDR"
+
+// StatsD sends collection cycles and numeric custom alerts as gauges
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+}
+
+// NewStatsDFromEnv returns a sink for MONKEY_STATSD_ADDR, or nil if it isn't set
+func NewStatsDFromEnv(host HostInfo) (*StatsD, error) {
+	addr := os.Getenv(StatsDAddrEnvVar)
+	if addr == "" {
+		return nil, nil
+	}
+	return NewStatsD(addr, host)
+}
+
+// NewStatsD creates a sink sending to the StatsD server at addr
+func NewStatsD(addr string, host HostInfo) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", StatsDAddrEnvVar, addr, err)
+	}
+	return &StatsD{conn: conn, prefix: hostPath(host)}, nil
+}
+
+// Name identifies the sink
+func (s *StatsD) Name() string {
+	return "statsd"
+}
+
+// Send writes every sample from the record as a gauge, other records are ignored
+func (s *StatsD) Send(rec *payload.Record) error {
+	var lines []string
+	for _, p := range recordPoints(rec) {
+		if math.IsNaN(p.value) || math.IsInf(p.value, 0) {
+			continue
+		}
+		name := s.prefix + "." + metricPath(p)
+		if p.value < 0 {
+			// a signed gauge is a relative change in StatsD, so zero it first
+			lines = append(lines, name+":0|g")
+		}
+		lines = append(lines, name+":"+strconv.FormatFloat(p.value, 'f', -1, 64)+"|g")
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return writeDatagrams(s.conn, lines)
+}
+
+// Close releases the UDP socket
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,141 @@
+// server.go
+// optional localhost-only HTTP listener reporting the agent's own health
+
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"go_monitor/logger"
+	"go_monitor/status"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+var log = logger.For("health")
+
+// Environment variable enabling the listener, e.g. 127.0.0.1:9187
+const AddrEnvVar = "MONKEY_HTTP_ADDR"
+
+// How stale the last main loop cycle may be before /healthz fails.
+// Has to cover the 60 second back off when the plan has too many hosts.
+const MaxCycleAge = 2 * time.Minute
+
+// How long the agent has to fetch config and start cycling after boot
+const StartupGrace = 5 * time.Minute
+
+// Server serves the agent's health endpoints on a loopback address
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+	srv  *http.Server
+}
+
+// NewServerFromEnv returns a server for MONKEY_HTTP_ADDR, or nil if it isn't set
+func NewServerFromEnv() (*Server, error) {
+	addr := os.Getenv(AddrEnvVar)
+	if addr == "" {
+		return nil, nil
+	}
+	return NewServer(addr)
+}
+
+// NewServer creates a server for addr, which must be a loopback address
+func NewServer(addr string) (*Server, error) {
+	if err := checkLoopback(addr); err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		addr: addr,
+		mux:  http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/healthz", handleHealthz)
+	s.mux.HandleFunc("/readyz", handleReadyz)
+	s.mux.HandleFunc("/status", handleStatus)
+	s.srv = &http.Server{
+		Handler:      s.mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	return s, nil
+}
+
+// Handle registers an extra handler on the server, must be called before Start
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start binds the listener and serves requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	log.Info("Serving local health endpoints", "addr", listener.Addr().String())
+
+	go func() {
+		if err := s.srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("Health server stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Stop closes the listener
+func (s *Server) Stop() {
+	s.srv.Close()
+}
+
+// checkLoopback makes sure the listener can't be reached from other hosts
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", AddrEnvVar, addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%s must be a loopback address, got %q", AddrEnvVar, addr)
+	}
+	return nil
+}
+
+// handleHealthz answers whether the main loop is still running
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if status.Healthy(MaxCycleAge, StartupGrace) {
+		writeText(w, http.StatusOK, "ok")
+		return
+	}
+	writeText(w, http.StatusServiceUnavailable, "main loop stalled")
+}
+
+// handleReadyz answers whether the agent is successfully delivering data
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if status.Ready() {
+		writeText(w, http.StatusOK, "ready")
+		return
+	}
+	writeText(w, http.StatusServiceUnavailable, "not ready")
+}
+
+// handleStatus returns the full agent state as JSON
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	jsonBytes, err := json.MarshalIndent(status.Snapshot(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func writeText(w http.ResponseWriter, code int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, body)
+}
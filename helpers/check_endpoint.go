@@ -1,19 +1,21 @@
 package helpers
 
 import (
-    "fmt"
+    "go_monitor/logger"
     "net"
     "net/url"
     "time"
 )
 
+var log = logger.For("helpers")
+
 func CheckEndpoint(endpoint string) bool {
     timeout := time.Second * 5
     maxRetries := 3  // Limit retries to prevent resource exhaustion
 
     parsedURL, err := url.Parse(endpoint)
     if err != nil {
-        fmt.Printf("Could not parse URL %s: %s\n", endpoint, err)
+        log.Error("Could not parse URL", "url", endpoint, "err", err)
         return false
     } 
 
@@ -26,29 +28,29 @@ func CheckEndpoint(endpoint string) bool {
     }
 
     addr := net.JoinHostPort(parsedURL.Hostname(), parsedURL.Port())
-    fmt.Println(addr)
+    log.Debug("Checking endpoint", "addr", addr)
 
     for i := 0; i < maxRetries; i++ {
         conn, err := net.DialTimeout("tcp", addr, timeout)
         if err != nil {
-            fmt.Printf("Could not connect to %s: %s (attempt %d/%d)\n", addr, err, i+1, maxRetries)
+            log.Warn("Could not connect to endpoint", "addr", addr, "attempt", i+1, "max_attempts", maxRetries, "err", err)
             if i < maxRetries-1 {
                 // Exponential backoff
                 sleepTime := time.Duration(1<<uint(i)) * time.Second
                 if sleepTime > 15*time.Second {
                     sleepTime = 15 * time.Second
                 }
-                fmt.Printf("Waiting %v before retrying...\n", sleepTime)
+                log.Debug("Waiting before retrying", "delay", sleepTime)
                 time.Sleep(sleepTime)
             }
             continue
         }
         
         conn.Close()  // Explicitly close the connection
-        fmt.Printf("Successfully connected to %s\n", addr)
+        log.Debug("Connected to endpoint", "addr", addr)
         return true
     }
     
-    fmt.Printf("Failed to connect to %s after %d attempts\n", addr, maxRetries)
+    log.Error("Failed to connect to endpoint", "addr", addr, "attempts", maxRetries)
     return false
 }
@@ -0,0 +1,249 @@
+// logger.go
+// structured, levelled logging shared by every part of the agent
+
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Environment variables controlling log output
+const (
+	LevelEnvVar     = "MONKEY_LOG_LEVEL"      // debug, info, warn or error
+	FormatEnvVar    = "MONKEY_LOG_FORMAT"     // logfmt (default) or json
+	RateLimitEnvVar = "MONKEY_LOG_RATE_LIMIT" // seconds to suppress repeated warnings/errors, 0 disables
+)
+
+// DefaultRateLimit is how long an identical warning or error is held back after it was logged
+const DefaultRateLimit = time.Minute
+
+// maxTrackedMessages bounds the rate limiter so unique messages can't grow it forever
+const maxTrackedMessages = 1024
+
+// root holds the handler every component logger writes through.
+// It is swapped by Setup so loggers created at package init pick up the final configuration.
+var root atomic.Pointer[rootHandler]
+
+func init() {
+	root.Store(newRootHandler(os.Stderr, slog.LevelInfo, "logfmt", DefaultRateLimit))
+}
+
+// Init configures the logger from the MONKEY_LOG_* environment variables
+func Init() error {
+	window := DefaultRateLimit
+	if env := os.Getenv(RateLimitEnvVar); env != "" {
+		seconds, err := strconv.Atoi(env)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("invalid %s %q", RateLimitEnvVar, env)
+		}
+		window = time.Duration(seconds) * time.Second
+	}
+	return Setup(os.Stderr, os.Getenv(LevelEnvVar), os.Getenv(FormatEnvVar), window)
+}
+
+// Setup replaces the output, level, format and rate limit window of every logger
+func Setup(w io.Writer, level, format string, window time.Duration) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "", "logfmt", "text":
+		format = "logfmt"
+	case "json":
+	default:
+		return fmt.Errorf("invalid log format %q (want logfmt or json)", format)
+	}
+	root.Store(newRootHandler(w, lvl, format, window))
+	return nil
+}
+
+// ParseLevel converts a level name into a slog level, defaulting to info
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", level)
+}
+
+// For returns a logger tagged with the given component name
+func For(component string) *slog.Logger {
+	return slog.New(&componentHandler{component: component, source: component})
+}
+
+// rootHandler formats records and rate limits repeated warnings and errors
+type rootHandler struct {
+	handler slog.Handler
+	level   slog.Level
+	window  time.Duration
+
+	mutex sync.Mutex
+	seen  map[string]*seenMessage
+}
+
+// seenMessage tracks when a message was last written and how many repeats were dropped since
+type seenMessage struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+func newRootHandler(w io.Writer, level slog.Level, format string, window time.Duration) *rootHandler {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return &rootHandler{
+		handler: h,
+		level:   level,
+		window:  window,
+		seen:    make(map[string]*seenMessage),
+	}
+}
+
+// allow reports whether a record may be written and how many identical ones were suppressed before it
+func (r *rootHandler) allow(key string, now time.Time) (bool, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if msg, ok := r.seen[key]; ok {
+		if now.Sub(msg.lastLogged) < r.window {
+			msg.suppressed++
+			return false, 0
+		}
+		suppressed := msg.suppressed
+		msg.lastLogged = now
+		msg.suppressed = 0
+		return true, suppressed
+	}
+
+	if len(r.seen) >= maxTrackedMessages {
+		for k, msg := range r.seen {
+			if now.Sub(msg.lastLogged) >= r.window {
+				delete(r.seen, k)
+			}
+		}
+		// Still full of active messages, let this one through untracked
+		if len(r.seen) >= maxTrackedMessages {
+			return true, 0
+		}
+	}
+	r.seen[key] = &seenMessage{lastLogged: now}
+	return true, 0
+}
+
+// repeatKey identifies repeats of a record for rate limiting: the same source, level,
+// message and identifying attributes. String and error attributes, like the file, proto
+// or err, tell different failures apart; numbers and times are readings that differ on
+// every repeat, so they're left out.
+func repeatKey(source string, record slog.Record) string {
+	var key strings.Builder
+	key.WriteString(source + "\x00" + record.Level.String() + "\x00" + record.Message)
+	record.Attrs(func(attr slog.Attr) bool {
+		value := attr.Value.Resolve()
+		switch value.Kind() {
+		case slog.KindString:
+			key.WriteString("\x00" + attr.Key + "=" + value.String())
+		case slog.KindAny:
+			if err, ok := value.Any().(error); ok {
+				key.WriteString("\x00" + attr.Key + "=" + err.Error())
+			}
+		}
+		return true
+	})
+	return key.String()
+}
+
+// componentHandler is the handler behind each For logger.
+// It resolves the root handler on every call so configuration changes apply everywhere.
+type componentHandler struct {
+	component string
+	chain     []handlerOp // WithAttrs/WithGroup calls replayed onto the root handler
+	source    string      // component plus With attributes, identifies repeats for rate limiting
+}
+
+// handlerOp is a single WithAttrs (attrs set) or WithGroup (group set) call
+type handlerOp struct {
+	attrs []slog.Attr
+	group string
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= root.Load().level
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	r := root.Load()
+
+	if r.window > 0 && record.Level >= slog.LevelWarn {
+		key := repeatKey(h.source, record)
+		ok, suppressed := r.allow(key, record.Time)
+		if !ok {
+			return nil
+		}
+		if suppressed > 0 {
+			record = record.Clone()
+			record.AddAttrs(slog.Int("suppressed", suppressed))
+		}
+	}
+
+	handler := r.handler.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	for _, op := range h.chain {
+		if op.group != "" {
+			handler = handler.WithGroup(op.group)
+		} else {
+			handler = handler.WithAttrs(op.attrs)
+		}
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(handlerOp{attrs: attrs})
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(handlerOp{group: name})
+}
+
+func (h *componentHandler) with(op handlerOp) *componentHandler {
+	chain := make([]handlerOp, 0, len(h.chain)+1)
+	chain = append(chain, h.chain...)
+	chain = append(chain, op)
+
+	source := h.source
+	if op.group != "" {
+		source += " " + op.group + "."
+	}
+	for _, attr := range op.attrs {
+		source += " " + attr.String()
+	}
+	return &componentHandler{component: h.component, chain: chain, source: source}
+}
@@ -6,6 +6,31 @@
 // 0.6.3 - Added process monitoring for CPU and Memory usage (in-memory storage)
 // 0.6.4 - Sends Processs CPU/Mem on boot to populate frontend nicely
 // 0.7.0 - Custom alerting
+// 0.7.1 - Structured levelled logging (MONKEY_LOG_LEVEL, MONKEY_LOG_FORMAT, MONKEY_LOG_RATE_LIMIT)
+// 0.7.2 - Local /healthz, /readyz and /status endpoint (MONKEY_HTTP_ADDR)
+// 0.7.3 - Prometheus /metrics endpoint (MONKEY_PROMETHEUS_ADDR)
+// 0.7.4 - OpenTelemetry OTLP/HTTP metrics exporter (MONKEY_OTLP_ENDPOINT)
+// 0.7.5 - Sinks: every record fans out to the API, Prometheus and OTLP with per sink buffers and filters (MONKEY_SINK_<NAME>_*)
+// 0.7.6 - InfluxDB line protocol (HTTP/UDP), StatsD and Graphite sinks (MONKEY_INFLUX_URL, MONKEY_STATSD_ADDR, MONKEY_GRAPHITE_ADDR)
+// 0.7.7 - MQTT sink with retained online/offline status and TLS (MONKEY_MQTT_BROKER), MONKEY_API_ENABLED=false for MQTT only sites
+// 0.7.8 - Rotating, gzipped local JSONL archive of everything sent (MONKEY_ARCHIVE_DIR)
+// 0.7.9 - --once and --dry-run print what would be sent instead of sending it
+// 0.8.0 - Control socket (MONKEY_CONTROL_SOCKET) and ctl reload|scan-ports|send-processes|pause|resume|status
+// 0.8.1 - Agent self-telemetry (RSS, heap, goroutines, FDs, cycle time, send latency, error counts) in the heartbeat
+// 0.8.2 - Clock skew against the server's Date header in the heartbeat, clock_skew event (MONKEY_CLOCK_SKEW_THRESHOLD, MONKEY_CLOCK_CORRECT)
+// 0.8.3 - Heartbeat Sequence, RunID and BootID, DroppedSamples counts heartbeats that never reached the server
+// 0.8.4 - Startup splay and jitter on the ports, processes and custom alert schedules, stable per host (MONKEY_SPLAY_SECONDS, MONKEY_JITTER_PERCENT)
+// 0.8.5 - Network intervals survive counter resets and wraps, bytes/sec and packets/sec rates over the real elapsed time
+// 0.8.6 - Per interface traffic, errors and drops, interfaces picked by type not name (MONKEY_NET_INCLUDE, MONKEY_NET_EXCLUDE, MONKEY_NET_EXCLUDE_TYPES)
+// 0.8.7 - Network interface inventory (addresses, MAC, MTU, link, speed, duplex) with link, speed and address change events
+// 0.8.8 - TCP/UDP health: retransmits, resets, listen overflows, UDP buffer errors and socket counts, with rates
+// 0.8.9 - Connections event: connections per listening port, top peers, sockets per state and CLOSE_WAIT leaks (MONKEY_CONNECTIONS_*)
+// 0.9.0 - Open ports list each bind address and family with the owning PID, process, user and executable
+// 0.9.1 - Ports scanned every minute, port_opened/port_closed events as soon as a listener changes (MONKEY_PORT_SCAN_INTERVAL)
+// 0.9.2 - Port policy of expected and forbidden public ports, port_policy_violation / port_policy_resolved events
+// 0.9.3 - Process CPU measured between samples of /proc/<pid>/stat instead of the lifetime average from ps
+// 0.9.4 - processes_summary event with peak/average CPU, peak RSS and time in the top 10 across the send window
+// 0.9.5 - iowait, incident_processes snapshots of the top CPU/memory/IO processes when load, memory or iowait cross a threshold
 package main
 
 import (
@@ -13,191 +38,673 @@ import (
     "go_monitor/monitors"
     "go_monitor/helpers"
     "go_monitor/events"
+    "go_monitor/clock"
     "go_monitor/custom"
+    "go_monitor/control"
+    "go_monitor/exporters"
+    "go_monitor/health"
+    "go_monitor/logger"
+    "go_monitor/payload"
+    "go_monitor/schedule"
+    "go_monitor/sinks"
+    "go_monitor/status"
     "time"
     "encoding/json"
     "net/http"
-    "bytes"
     "os"
-    "io"
     "flag"
+    "runtime"
     "runtime/debug"
     "strconv"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "sync"
+    "os/signal"
+    "syscall"
+    "strings"
 )
 
 // Version information
-const AgentVersion = "0.7.0" 
+const AgentVersion = "0.9.5"
+
+// Set to false to stop sending to the Monitor Monkey API, e.g. when MQTT is the only uplink
+const APIEnabledEnvVar = "MONKEY_API_ENABLED"
 
 type Custom struct {
     Disks []string
     Services []string
+    PortPolicy *events.PortPolicy `json:",omitempty"`
 }
 
-type mesure struct {
-    Heartbeat int64
-    Hostid string
-    Hostname string
-    Uptime uint64
-    Os string
-    Platform string
-    Ip string
-    Temp  []monitors.TemperatureReading
-    Load  map[string]float64
-    Disks map[string]float64
-    Memory float64
-    Upload uint64
-    Download uint64
-    UploadInterval uint64
-    DownloadInterval uint64
-    Services map[string]string
-    AgentVer string
-}
-
-func log(to_log error) {
-    fmt.Println(to_log)
-}
-
-// sendOpenPortsEvent gets open ports information and sends it to the events API
-func sendOpenPortsEvent(client *http.Client, baseURL string, authHeader string) {
-    // Get host ID and other details
-    hostid, _, _, _, _, _ := monitors.GetHostDetails()
-    
-    // Get open ports data
-    jsonData, err := events.GetOpenPortsJSON()
+var log = logger.For("agent")
+
+// configVersion returns a short hash identifying the disks and services being monitored
+func configVersion(disks []string, services []string) string {
+    jsonBytes, _ := json.Marshal(Custom{Disks: disks, Services: services})
+    sum := sha256.Sum256(jsonBytes)
+    return hex.EncodeToString(sum[:])[:12]
+}
+
+// collect runs a collector through the status tracker, logging any failure
+func collect(name string, fn func() error) {
+    if err := status.TimeCollector(name, fn); err != nil {
+        log.With("collector", name).Error("Collector failed", "err", err)
+    }
+}
+
+// printLocalStatus prints what the running agent reports on its local status endpoint
+func printLocalStatus(addr string) {
+    client := &http.Client{Timeout: 5 * time.Second}
+    resp, err := client.Get("http://" + addr + "/status")
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error getting open ports: %v\n", err)
+        fmt.Printf("Agent:    not reachable on %s (%v)\n", addr, err)
         return
     }
-    
-    // Parse the JSON string back to a map for embedding in event data
-    var portsData interface{}
-    err = json.Unmarshal([]byte(jsonData), &portsData)
-    if err != nil {
-        fmt.Fprintf(os.Stderr, "Error parsing ports data: %v\n", err)
+    defer resp.Body.Close()
+
+    var s status.Status
+    if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+        fmt.Printf("Agent:    invalid status response (%v)\n", err)
         return
     }
-    
-    // Create event payload
-    eventPayload := map[string]interface{}{
-        "Hostid":     hostid,
-        "EventType":  "open_ports",
-        "EventData":  portsData,
+    printStatus(s)
+}
+
+// printStatus prints the state reported by the running agent
+func printStatus(s status.Status) {
+    fmt.Printf("Running:  %s (up %d seconds)\n", s.Version, s.UptimeSeconds)
+    fmt.Printf("Run:      %s, heartbeat %d, %d dropped\n", s.RunID, s.Sequence, s.DroppedSamples)
+    if s.LastSuccess.IsZero() {
+        fmt.Println("Last send: never")
+    } else {
+        fmt.Printf("Last send: %s\n", s.LastSuccess.Format(time.RFC3339))
+    }
+    fmt.Printf("Failures: %d consecutive\n", s.ConsecutiveFailures)
+    fmt.Printf("Queue:    %d\n", s.QueueDepth)
+    fmt.Printf("Config:   %s\n", s.ConfigVersion)
+    if s.Paused {
+        fmt.Println("Sending:  paused")
+    }
+    for _, sink := range s.Sinks {
+        fmt.Printf("Sink %s: queue %d, %d sent, %d failed, %d dropped\n", sink.Name, sink.QueueDepth, sink.Sent, sink.Failed, sink.Dropped)
+    }
+    for name, c := range s.Collectors {
+        if c.LastError != "" {
+            fmt.Printf("Collector %s: %d errors, last: %s\n", name, c.Errors, c.LastError)
+        }
+    }
+}
+
+// counterTrackers hold the previous readings of the counters a cycle reports the change in
+type counterTrackers struct {
+    net    *monitors.NetTracker
+    stack  *monitors.NetStackTracker
+    iowait *monitors.IOWaitTracker
+}
+
+// newCounterTrackers creates the trackers and takes their baseline readings
+func newCounterTrackers(netFilter monitors.NetFilter) *counterTrackers {
+    t := &counterTrackers{
+        net:    monitors.NewNetTracker(netFilter),
+        stack:  monitors.NewNetStackTracker(),
+        iowait: monitors.NewIOWaitTracker(),
+    }
+    if _, err := t.net.Read(); err != nil {
+        log.Error("Failed to read network counters", "err", err)
+    }
+    if _, err := t.stack.Read(); err != nil {
+        log.Error("Failed to read TCP/UDP counters", "err", err)
+    }
+    if _, _, err := t.iowait.Read(); err != nil {
+        log.Error("Failed to read CPU times", "err", err)
+    }
+    return t
+}
+
+// portTrackers remember what earlier open ports scans found
+type portTrackers struct {
+    changes *events.PortWatcher
+    policy  *events.PortPolicyChecker
+}
+
+// newPortTrackers creates the trackers, checking scans against the local port policy
+// until the API sends one
+func newPortTrackers() *portTrackers {
+    t := &portTrackers{
+        changes: events.NewPortWatcher(),
+        policy:  events.NewPortPolicyChecker(),
+    }
+    policy, err := events.LoadPortPolicy()
+    if err == nil {
+        err = t.policy.SetLocalPolicy(policy)
     }
-    
-    // Marshal the payload
-    jsonBytes, err := json.Marshal(eventPayload)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error marshaling event data: %v\n", err)
-        return
+        log.Error("Ignoring invalid port policy", "err", err)
     }
+    return t
+}
+
+// collectMesure runs one collection cycle. Network intervals and rates are the
+// change since the trackers' previous readings.
+func collectMesure(disks []string, services []string, trackers *counterTrackers) *payload.Mesure {
+    // Create maps each cycle
+    loadmap := make(map[string]float64)
+    diskmap := make(map[string]float64)
+    servicemap := make(map[string]string)
+
+    start := time.Now()
+    m := &payload.Mesure{}
+    m.Heartbeat = start.Unix()
+
+    m.Hostid, m.Hostname, m.Uptime, m.Os, m.Platform, m.Ip = monitors.GetHostDetails()
+    m.RunID = status.RunID()
+    m.Sequence = status.NextSequence()
+    m.DroppedSamples = status.DroppedSamples()
+    collect("boot_id", func() (err error) {
+        m.BootID, err = monitors.GetBootID()
+        return err
+    })
+    collect("temp", func() (err error) {
+        m.Temp, err = monitors.GetTemp()
+        return err
+    })
+    collect("load", func() (err error) {
+        m.Load, err = monitors.GetLoad(loadmap)
+        return err
+    })
+    collect("iowait", func() error {
+        iowait, ok, err := trackers.iowait.Read()
+        if ok {
+            m.IOWait = &iowait
+        }
+        return err
+    })
+    collect("disks", func() error {
+        var errs []error
+        for _, disk := range disks {
+            usage, err := monitors.GetDiskUsage(disk)
+            if err != nil {
+                errs = append(errs, fmt.Errorf("%s: %w", disk, err))
+            }
+            diskmap[disk] = usage
+        }
+        return errors.Join(errs...)
+    })
+    m.Disks = diskmap
+    collect("memory", func() (err error) {
+        m.Memory, err = monitors.GetMem()
+        return err
+    })
+    collect("network", func() error {
+        net, err := trackers.net.Read()
+        if err != nil {
+            return err
+        }
+        m.Upload, m.Download = net.Upload, net.Download
+        m.UploadInterval, m.DownloadInterval = net.UploadInterval, net.DownloadInterval
+        m.UploadRate, m.DownloadRate = net.UploadRate, net.DownloadRate
+        m.PacketsSentRate, m.PacketsRecvRate = net.PacketsSentRate, net.PacketsRecvRate
+        m.Interfaces = net.Interfaces
+        return nil
+    })
+    collect("netstack", func() error {
+        stack, err := trackers.stack.Read()
+        if err != nil {
+            return err
+        }
+        m.NetStack = &stack
+        return nil
+    })
+    m.AgentVer = AgentVersion
     
-    // Create and send the request
-    eventsApi := baseURL + "/api/events/"
-    req, err := http.NewRequest("POST", eventsApi, bytes.NewBuffer(jsonBytes))
+    collect("services", func() error {
+        for _, service := range services {
+            servicemap[service] = monitors.ServiceCheck(service)
+        }
+        return nil
+    })
+    m.Services = servicemap
+
+    status.RecordCycleDuration(time.Since(start))
+    m.Agent = agentStats()
+
+    return m
+}
+
+// agentStats reports the agent's own footprint along with its timings and error counts
+func agentStats() *monitors.AgentStats {
+    stats := &monitors.AgentStats{}
+    collect("agent", func() (err error) {
+        *stats, err = monitors.GetSelfStats()
+        return err
+    })
+
+    s := status.Snapshot()
+    stats.CycleMs = s.LastCycleMs
+    stats.SendLatencyMs = s.LastSendLatencyMs
+    stats.SendErrors = s.SendErrors
+    for _, c := range s.Collectors {
+        stats.CollectorErrors += c.Errors
+    }
+    for _, sink := range s.Sinks {
+        stats.SinkFailures += sink.Failed
+        stats.SinkDropped += sink.Dropped
+    }
+    return stats
+}
+
+// runOnce publishes a single collection cycle, the open ports, the top processes and
+// every custom alert, then waits for them to be printed. Returns the exit code,
+// 1 if any collector failed.
+func runOnce(dispatcher *sinks.Dispatcher, config *monitoredConfig, hostid string, sched *schedule.Scheduler, netFilter monitors.NetFilter, trackers *counterTrackers, ports *portTrackers) int {
+    disks, services := config.get()
+    status.RecordCycle()
+    dispatcher.Publish(payload.NewMesureRecord(collectMesure(disks, services, trackers)))
+
+    sendOpenPortsEvent(dispatcher, hostid, ports)
+    sendInterfacesEvent(dispatcher, hostid, netFilter)
+    connectionOpts, _ := events.ConnectionOptionsFromEnv()
+    sendConnectionsEvent(dispatcher, hostid, events.NewConnectionWatcher(connectionOpts))
+
+    err := status.TimeCollector("processes", func() error {
+        return events.CollectProcesses(10) // Get top 10 processes
+    })
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-        return
+        log.Error("Failed to collect processes", "err", err)
+    } else {
+        sendProcessesEvents(dispatcher, hostid)
     }
-    
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", authHeader)
-    
-    resp, err := client.Do(req)
+
+    custom.NewAlertMonitor(dispatcher.Publish, hostid, sched).RunOnce()
+    dispatcher.Stop(10 * time.Second)
+
+    for _, c := range status.Snapshot().Collectors {
+        if c.LastError != "" {
+            return 1
+        }
+    }
+    return 0
+}
+
+// fetchConfig asks the API for this host's disks and services and applies them
+func fetchConfig(api *sinks.API, hostDetails map[string]interface{}, config *monitoredConfig) error {
+    body, err := api.Configure(hostDetails)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error sending event: %v\n", err)
-        return
+        return err
     }
-    defer resp.Body.Close()
-    
-    // Log success or failure
-    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-        fmt.Printf("Successfully sent open ports event at %s\n", time.Now().Format(time.RFC3339))
-    } else {
-        body, _ := io.ReadAll(resp.Body)
-        fmt.Fprintf(os.Stderr, "Failed to send event. Status: %d, Response: %s\n", 
-            resp.StatusCode, string(body))
+
+    var confResponse map[string]interface{}
+    err = json.Unmarshal(body, &confResponse)
+    if err != nil {
+        return fmt.Errorf("failed to parse configuration response: %w", err)
+    }
+    if value, ok := confResponse["message"]; ok && value == "noconf" {
+        log.Info("No configuration changes needed")
+        return nil
     }
+    config.apply(body)
+    return nil
 }
 
-// sendProcessesEvent sends process data to the events API
-func sendProcessesEvent(client *http.Client, baseURL string, authHeader string, metric string) {
-    // Get host ID and other details
-    hostid, _, _, _, _, _ := monitors.GetHostDetails()
-    
-    // Get the process data from memory
-    jsonData, err := events.GetProcessesJSON(metric)
+// monitoredConfig holds the disks and services to check and the port policy, updated from API responses
+type monitoredConfig struct {
+    mutex      sync.Mutex
+    disks      []string
+    services   []string
+    portPolicy *events.PortPolicyChecker
+}
+
+// get returns the disks and services currently configured
+func (c *monitoredConfig) get() ([]string, []string) {
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    return c.disks, c.services
+}
+
+// apply updates the configuration from an API response body
+func (c *monitoredConfig) apply(body []byte) {
+    var custom Custom
+    err := json.Unmarshal(body, &custom)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error getting %s processes data: %v\n", metric, err)
-        return
+        log.Error("Failed to parse custom configuration", "err", err)
     }
-    
-    // Parse the JSON string back to a map for embedding in event data
-    var processData interface{}
-    err = json.Unmarshal([]byte(jsonData), &processData)
+
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    if custom.Disks != nil {
+        c.disks = custom.Disks
+    }
+    if custom.Services != nil {
+        c.services = custom.Services
+    }
+    // the API's policy replaces the local one, a response without one brings the
+    // local policy back
+    if err == nil && c.portPolicy != nil {
+        var policy events.PortPolicy
+        if custom.PortPolicy != nil {
+            policy = *custom.PortPolicy
+        }
+        if err := c.portPolicy.SetPolicy(policy); err != nil {
+            log.Error("Ignoring invalid port policy from the API", "err", err)
+        }
+    }
+    status.SetConfigVersion(configVersion(c.disks, c.services))
+}
+
+// startLocalEndpoints starts the health endpoint and Prometheus listener if configured
+func startLocalEndpoints() *exporters.Prometheus {
+    healthServer, err := health.NewServerFromEnv()
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error parsing %s processes data: %v\n", metric, err)
-        return
+        log.Error("Local health endpoint disabled", "err", err)
     }
-    
-    // Create event payload
-    eventType := ""
-    if metric == "cpu" {
-        eventType = "processes_cpu"
-    } else if metric == "mem" {
-        eventType = "processes_mem"
-    } else {
-        fmt.Fprintf(os.Stderr, "Invalid metric: %s\n", metric)
-        return
+
+    // Prometheus metrics share the health listener when given the same address
+    var prometheus *exporters.Prometheus
+    if addr := os.Getenv(exporters.PrometheusAddrEnvVar); addr != "" {
+        prometheus = exporters.NewPrometheus()
+        if healthServer != nil && addr == os.Getenv(health.AddrEnvVar) {
+            healthServer.Handle("/metrics", prometheus)
+        } else if err := exporters.ServePrometheus(addr, prometheus); err != nil {
+            log.Error("Prometheus metrics disabled", "err", err)
+            prometheus = nil
+        }
     }
-    
-    eventPayload := map[string]interface{}{
-        "Hostid":     hostid,
-        "EventType":  eventType,
-        "EventData":  processData,
+
+    if healthServer != nil {
+        if err := healthServer.Start(); err != nil {
+            log.Error("Local health endpoint disabled", "err", err)
+        }
     }
-    
-    // Marshal the payload
-    jsonBytes, err := json.Marshal(eventPayload)
+    return prometheus
+}
+
+// addOutputSinks registers every other destination that is configured in the environment.
+// mqttUplink is set when MQTT replaces the API.
+func addOutputSinks(dispatcher *sinks.Dispatcher, hostInfo exporters.HostInfo, mqttUplink bool) {
+    // Set up the OpenTelemetry exporter if configured
+    otlp, err := exporters.NewOTLPFromEnv(hostInfo)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error marshaling event data: %v\n", err)
-        return
+        log.Error("OTLP exporter disabled", "err", err)
+    } else if otlp != nil {
+        log.Info("Exporting metrics to OTLP endpoint", "endpoint", os.Getenv(exporters.OTLPEndpointEnvVar))
+        addSink(dispatcher, otlp)
     }
-    
-    // Create and send the request
-    eventsApi := baseURL + "/api/events/"
-    req, err := http.NewRequest("POST", eventsApi, bytes.NewBuffer(jsonBytes))
+
+    // InfluxDB, StatsD and Graphite sinks if configured
+    influx, err := exporters.NewInfluxFromEnv(hostInfo)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-        return
+        log.Error("InfluxDB sink disabled", "err", err)
+    } else if influx != nil {
+        log.Info("Writing metrics to InfluxDB")
+        addSink(dispatcher, influx)
     }
-    
-    req.Header.Set("Content-Type", "application/json")
-    req.Header.Set("Authorization", authHeader)
-    
-    resp, err := client.Do(req)
+
+    statsd, err := exporters.NewStatsDFromEnv(hostInfo)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error sending event: %v\n", err)
-        return
+        log.Error("StatsD sink disabled", "err", err)
+    } else if statsd != nil {
+        log.Info("Sending metrics to StatsD", "addr", os.Getenv(exporters.StatsDAddrEnvVar))
+        addSink(dispatcher, statsd)
     }
-    defer resp.Body.Close()
-    
-    // Log success or failure
-    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-        fmt.Printf("Successfully sent %s processes event at %s\n", metric, time.Now().Format(time.RFC3339))
-    } else {
-        body, _ := io.ReadAll(resp.Body)
-        fmt.Fprintf(os.Stderr, "Failed to send %s processes event. Status: %d, Response: %s\n", 
-            metric, resp.StatusCode, string(body))
+
+    graphite, err := exporters.NewGraphiteFromEnv(hostInfo)
+    if err != nil {
+        log.Error("Graphite sink disabled", "err", err)
+    } else if graphite != nil {
+        log.Info("Sending metrics to Graphite", "addr", os.Getenv(exporters.GraphiteAddrEnvVar))
+        addSink(dispatcher, graphite)
+    }
+
+    // MQTT for sites where a broker is the uplink
+    mqttOpts, ok, err := sinks.MQTTOptionsFromEnv(hostInfo.Hostid, hostInfo.Hostname)
+    if err != nil {
+        log.Error("MQTT sink disabled", "err", err)
+    } else if ok {
+        log.Info("Publishing to MQTT broker", "broker", mqttOpts.Broker, "topic_prefix", mqttOpts.TopicPrefix)
+        mqttOpts.Uplink = mqttUplink
+        addSink(dispatcher, sinks.NewMQTT(mqttOpts))
+    }
+
+    // Local archive of everything sent if configured
+    archiveOpts, ok, err := sinks.ArchiveOptionsFromEnv()
+    if err == nil && ok {
+        var archive *sinks.Archive
+        archive, err = sinks.NewArchive(archiveOpts)
+        if err == nil {
+            log.Info("Archiving records locally", "dir", archiveOpts.Dir)
+            addSink(dispatcher, archive)
+        }
+    }
+    if err != nil {
+        log.Error("Local archive disabled", "err", err)
+    }
+}
+
+// runCtl sends a command to the running agent's control socket and prints the reply
+func runCtl(args []string) int {
+    if len(args) != 1 {
+        fmt.Fprintln(os.Stderr, "Usage: monitor-monkey-agent ctl reload|scan-ports|send-processes|pause|resume|status|help")
+        return 2
+    }
+
+    path := control.SocketPath()
+    if path == "" {
+        fmt.Fprintf(os.Stderr, "Error: the control socket is disabled by %s\n", control.SocketEnvVar)
+        return 1
+    }
+
+    resp, err := control.Send(path, args[0])
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+    if !resp.OK {
+        fmt.Fprintf(os.Stderr, "Error: %s\n", resp.Error)
+        return 1
+    }
+
+    if resp.Message != "" {
+        fmt.Println(strings.TrimRight(resp.Message, "\n"))
+    }
+    if args[0] == "status" {
+        var s status.Status
+        if err := json.Unmarshal(resp.Result, &s); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: invalid status (%v)\n", err)
+            return 1
+        }
+        printStatus(s)
+    }
+    return 0
+}
+
+// startControlSocket serves the ctl commands, driving the same paths as the main loop
+func startControlSocket(path string, dispatcher *sinks.Dispatcher, api *sinks.API, hostDetails map[string]interface{}, config *monitoredConfig, alertMonitor *custom.AlertMonitor, ports *portTrackers, hostid string) {
+    ctl := control.NewServer(path)
+
+    ctl.Handle("reload", "Fetch the configuration again and rescan custom alerts", func() (string, interface{}, error) {
+        if api != nil {
+            if err := fetchConfig(api, hostDetails, config); err != nil {
+                return "", nil, fmt.Errorf("failed to fetch configuration: %w", err)
+            }
+        }
+        count := alertMonitor.Reload()
+        return fmt.Sprintf("Configuration %s, %d custom alerts loaded", status.Snapshot().ConfigVersion, count), nil, nil
+    })
+
+    ctl.Handle("scan-ports", "Send the open ports event now", func() (string, interface{}, error) {
+        if err := sendOpenPortsEvent(dispatcher, hostid, ports); err != nil {
+            return "", nil, err
+        }
+        return "Open ports event queued", nil, nil
+    })
+
+    ctl.Handle("send-processes", "Take a process snapshot and send the processes events now", func() (string, interface{}, error) {
+        err := status.TimeCollector("processes", func() error {
+            return events.CollectProcesses(10) // Get top 10 processes
+        })
+        if err != nil {
+            return "", nil, err
+        }
+        sendProcessesEvents(dispatcher, hostid)
+        return "Processes events queued", nil, nil
+    })
+
+    ctl.Handle("pause", "Stop sending, collection carries on", func() (string, interface{}, error) {
+        dispatcher.SetPaused(true)
+        status.SetPaused(true)
+        return "Sending paused", nil, nil
+    })
+
+    ctl.Handle("resume", "Start sending again", func() (string, interface{}, error) {
+        dispatcher.SetPaused(false)
+        status.SetPaused(false)
+        return "Sending resumed", nil, nil
+    })
+
+    ctl.Handle("status", "Show the agent's live status", func() (string, interface{}, error) {
+        return "", status.Snapshot(), nil
+    })
+
+    if err := ctl.Start(); err != nil {
+        log.Error("Control socket disabled", "err", err)
+    }
+}
+
+// stopOnSignal flushes the sinks and exits when the service is stopped
+func stopOnSignal(dispatcher *sinks.Dispatcher) {
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+    go func() {
+        sig := <-signals
+        log.Info("Shutting down", "signal", sig.String())
+        dispatcher.Stop(5 * time.Second)
+        os.Exit(0)
+    }()
+}
+
+// addSink registers a sink with its MONKEY_SINK_<NAME>_* options
+func addSink(dispatcher *sinks.Dispatcher, sink sinks.Sink) {
+    opts, err := sinks.OptionsFromEnv(sink.Name())
+    if err != nil {
+        log.Error("Ignoring invalid sink options", "sink", sink.Name(), "err", err)
+        opts = sinks.Options{BufferSize: sinks.DefaultBufferSize}
     }
+    dispatcher.Add(sink, opts)
 }
 
-// sendProcessesEvents collects and sends both CPU and Memory process data to the events API
-func sendProcessesEvents(client *http.Client, baseURL string, authHeader string) {
+// scanOpenPorts gets open ports information and publishes a port_opened or
+// port_closed event for every port that changed since the previous scan, and
+// an event for every port policy violation that started or ended
+func scanOpenPorts(dispatcher *sinks.Dispatcher, hostid string, ports *portTrackers) (events.OpenPorts, error) {
+    // Get open ports data
+    var openPorts events.OpenPorts
+    err := status.TimeCollector("ports", func() (err error) {
+        openPorts, err = events.GetOpenPorts()
+        return err
+    })
+    if err != nil {
+        log.Error("Failed to get open ports", "err", err)
+        return openPorts, err
+    }
+
+    opened, closed := ports.changes.Check(openPorts)
+    for _, port := range opened {
+        dispatcher.Publish(payload.NewEventRecord(hostid, events.PortOpenedEvent, port))
+    }
+    for _, port := range closed {
+        dispatcher.Publish(payload.NewEventRecord(hostid, events.PortClosedEvent, port))
+    }
+
+    violations, resolved := ports.policy.Check(openPorts)
+    for _, violation := range violations {
+        dispatcher.Publish(payload.NewEventRecord(hostid, events.PortPolicyViolationEvent, violation))
+    }
+    for _, violation := range resolved {
+        dispatcher.Publish(payload.NewEventRecord(hostid, events.PortPolicyResolvedEvent, violation))
+    }
+    return openPorts, nil
+}
+
+// sendOpenPortsEvent scans the open ports and publishes the full list as an event
+func sendOpenPortsEvent(dispatcher *sinks.Dispatcher, hostid string, ports *portTrackers) error {
+    openPorts, err := scanOpenPorts(dispatcher, hostid, ports)
+    if err != nil {
+        return err
+    }
+
+    dispatcher.Publish(payload.NewEventRecord(hostid, "open_ports", openPorts))
+    return nil
+}
+
+// sendInterfacesEvent publishes the inventory of every network interface
+func sendInterfacesEvent(dispatcher *sinks.Dispatcher, hostid string, filter monitors.NetFilter) error {
+    var inventory events.NetworkInterfaces
+    err := status.TimeCollector("interfaces", func() (err error) {
+        inventory.Interfaces, err = monitors.GetInterfaces(filter)
+        return err
+    })
+    if err != nil {
+        log.Error("Failed to get network interfaces", "err", err)
+        return err
+    }
+
+    dispatcher.Publish(payload.NewEventRecord(hostid, "network_interfaces", inventory))
+    return nil
+}
+
+// checkInterfaces publishes an event for every link, speed or address change since the previous check
+func checkInterfaces(dispatcher *sinks.Dispatcher, hostid string, watcher *events.InterfaceWatcher) {
+    collect("interfaces", func() error {
+        changes, err := watcher.Check()
+        for _, change := range changes {
+            dispatcher.Publish(payload.NewEventRecord(hostid, change.Type, change))
+        }
+        return err
+    })
+}
+
+// sendConnectionsEvent publishes a summary of the TCP connection table
+func sendConnectionsEvent(dispatcher *sinks.Dispatcher, hostid string, watcher *events.ConnectionWatcher) error {
+    var summary events.ConnectionSummary
+    err := status.TimeCollector("connections", func() (err error) {
+        summary, err = watcher.Summarise()
+        return err
+    })
+    if err != nil {
+        log.Error("Failed to summarise connections", "err", err)
+        return err
+    }
+
+    dispatcher.Publish(payload.NewEventRecord(hostid, "connections", summary))
+    return nil
+}
+
+// sendProcessesEvents publishes the latest CPU and Memory process data and the
+// summary of the window since the previous send as events
+func sendProcessesEvents(dispatcher *sinks.Dispatcher, hostid string) {
+    // Get the process data from memory
+    cpuStats, memStats := events.GetTopProcesses()
+
     // Send CPU processes
-    sendProcessesEvent(client, baseURL, authHeader, "cpu")
-    
+    if len(cpuStats) == 0 {
+        log.Error("No CPU process data collected yet")
+    } else {
+        dispatcher.Publish(payload.NewEventRecord(hostid, "processes_cpu", cpuStats))
+    }
+
     // Send Memory processes
-    sendProcessesEvent(client, baseURL, authHeader, "mem")
+    if len(memStats) == 0 {
+        log.Error("No Memory process data collected yet")
+    } else {
+        dispatcher.Publish(payload.NewEventRecord(hostid, "processes_mem", memStats))
+    }
+
+    // Send the peaks and averages of every sample since the last send
+    summary := events.GetProcessSummary(10)
+    if summary.Samples > 0 {
+        dispatcher.Publish(payload.NewEventRecord(hostid, "processes_summary", summary))
+    }
     
     // Clear process data after sending to help with garbage collection
     events.ClearProcessData()
@@ -206,28 +713,61 @@ func sendProcessesEvents(client *http.Client, baseURL string, authHeader string)
     debug.FreeOSMemory()
 }
 
+// incidentMetrics picks the metrics the incident triggers watch out of a collection cycle
+func incidentMetrics(m *payload.Mesure) map[string]float64 {
+    metrics := map[string]float64{events.IncidentMemoryPercent: m.Memory}
+    if load1, ok := m.Load["load1"]; ok {
+        metrics[events.IncidentLoadPerCore] = load1 / float64(runtime.NumCPU())
+    }
+    if m.IOWait != nil {
+        metrics[events.IncidentIOWaitPercent] = *m.IOWait
+    }
+    return metrics
+}
+
+// sendIncidentSnapshot captures the top processes while an incident is happening and publishes them
+func sendIncidentSnapshot(dispatcher *sinks.Dispatcher, hostid string, triggers []events.IncidentTrigger) {
+    var snapshot events.IncidentSnapshot
+    err := status.TimeCollector("incident", func() (err error) {
+        snapshot, err = events.CaptureIncident(triggers, 10)
+        return err
+    })
+    if err != nil {
+        log.Error("Failed to capture incident processes", "err", err)
+        return
+    }
+    dispatcher.Publish(payload.NewEventRecord(hostid, events.IncidentEvent, snapshot))
+}
+
 // collectProcessData collects process data on a regular schedule (more frequently than sending)
-func collectProcessData(interval time.Duration, stopChan <-chan struct{}) {
-    ticker := time.NewTicker(interval)
+// Each sample is published for sinks that want it more often than the daily events
+func collectProcessData(interval time.Duration, stopChan <-chan struct{}, dispatcher *sinks.Dispatcher, sched *schedule.Scheduler) {
+    ticker := sched.NewTicker("process_collection", interval)
     defer ticker.Stop()
     
     // Collect initial data
-    err := events.CollectProcesses(10) // Get top 10 processes
+    err := status.TimeCollector("processes", func() error {
+        return events.CollectProcesses(10) // Get top 10 processes
+    })
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error in initial process data collection: %v\n", err)
+        log.Error("Initial process data collection failed", "err", err)
     } else {
-        fmt.Println("Initial process data collection completed")
+        log.Debug("Initial process data collection completed")
+        dispatcher.Publish(payload.NewProcessesRecord(events.GetTopProcesses()))
     }
     
     for {
         select {
         case <-ticker.C:
             // Update process data periodically
-            err := events.CollectProcesses(10) // Get top 10 processes
+            err := status.TimeCollector("processes", func() error {
+                return events.CollectProcesses(10) // Get top 10 processes
+            })
             if err != nil {
-                fmt.Fprintf(os.Stderr, "Error collecting processes: %v\n", err)
+                log.Error("Failed to collect processes", "err", err)
             } else {
-                fmt.Println("Process data updated at", time.Now().Format(time.RFC3339))
+                log.Debug("Process data updated")
+                dispatcher.Publish(payload.NewProcessesRecord(events.GetTopProcesses()))
             }
         case <-stopChan:
             return
@@ -239,18 +779,29 @@ func main() {
     // Set up a recovery function to prevent crashes
     defer func() {
         if r := recover(); r != nil {
-            fmt.Println("Recovered from panic:", r)
-            fmt.Println(string(debug.Stack()))
+            log.Error("Recovered from panic", "panic", r, "stack", string(debug.Stack()))
             time.Sleep(time.Second * 10)
             main() // Restart the main function
         }
     }()
 
+    // Commands for the running agent, e.g. ctl reload
+    if len(os.Args) > 1 && os.Args[1] == "ctl" {
+        os.Exit(runCtl(os.Args[2:]))
+    }
+
     // Parse command line arguments
     versionFlag := flag.Bool("version", false, "Display agent version")
     statusFlag := flag.Bool("status", false, "Display agent status")
+    onceFlag := flag.Bool("once", false, "Run one collection cycle, print what would be sent and exit (non-zero if a collector failed)")
+    dryRunFlag := flag.Bool("dry-run", false, "Run normally but print what would be sent instead of sending it")
     flag.Parse()
 
+    if err := logger.Init(); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+
     // Handle version flag
     if *versionFlag {
         fmt.Printf("Monitor Monkey Agent version %s\n", AgentVersion)
@@ -274,27 +825,48 @@ func main() {
         // Check if the service is running properly
         serviceStatus := monitors.ServiceCheck("monitor-monkey")
         fmt.Printf("Service:  %s\n", serviceStatus)
+
+        // Ask the running agent if its local endpoint is enabled
+        if addr := os.Getenv(health.AddrEnvVar); addr != "" {
+            printLocalStatus(addr)
+        }
         
         os.Exit(0)
     }
 
     // Standard agent operation
+    // The API can be turned off where another sink (e.g. MQTT) is the only uplink
+    apiEnabled := true
+    if env := os.Getenv(APIEnabledEnvVar); env != "" {
+        enabled, err := strconv.ParseBool(env)
+        if err != nil {
+            log.Error("Invalid "+APIEnabledEnvVar, "value", env)
+            os.Exit(1)
+        }
+        apiEnabled = enabled
+    }
+
+    // --once and --dry-run print records instead of sending them anywhere
+    localOnly := *onceFlag || *dryRunFlag
+
     token := os.Getenv("MONKEY_API_KEY")
-    if token == "" {
-        fmt.Println("Error: MONKEY_API_KEY environment variable is not set")
+    if token == "" && apiEnabled && !localOnly {
+        log.Error("MONKEY_API_KEY environment variable is not set")
         os.Exit(1)
     }
     
     authHeader := "token " + token
+    status.SetVersion(AgentVersion)
+
+    // Local endpoints aren't needed for a single cycle
+    var prometheus *exporters.Prometheus
+    if !*onceFlag {
+        prometheus = startLocalEndpoints()
+    }
     //change
     const baseURL = "https://monitormonkey.io"
     //const baseURL = "http://192.168.1.131:8000"
 
-    var (
-        updateApi = baseURL + "/api/update/"
-        confApi = baseURL + "/api/configure/"
-    )
-
     // Create an HTTP client with timeout settings to prevent connection leaks
     client := &http.Client{
         Timeout: 30 * time.Second,
@@ -317,36 +889,38 @@ func main() {
     if envInterval := os.Getenv("PROCESS_COLLECTION_INTERVAL"); envInterval != "" {
         if seconds, err := strconv.Atoi(envInterval); err == nil && seconds > 0 {
             processCollectionInterval = time.Duration(seconds) * time.Second
-            fmt.Printf("Using custom process collection interval: %d seconds\n", seconds)
+            log.Info("Using custom process collection interval", "seconds", seconds)
         }
     }
     
     if envInterval := os.Getenv("PROCESS_SEND_INTERVAL"); envInterval != "" {
         if seconds, err := strconv.Atoi(envInterval); err == nil && seconds > 0 {
             processSendInterval = time.Duration(seconds) * time.Second
-            fmt.Printf("Using custom process send interval: %d seconds\n", seconds)
+            log.Info("Using custom process send interval", "seconds", seconds)
         }
     }
     
-    fmt.Println("Process monitoring: Collection every", processCollectionInterval, "| Sending every", processSendInterval)
-    fmt.Println("For testing, set PROCESS_COLLECTION_INTERVAL and PROCESS_SEND_INTERVAL env vars (in seconds)")
-    
-    // Start process data collection in a goroutine
-    stopProcessCollection := make(chan struct{})
-    go collectProcessData(processCollectionInterval, stopProcessCollection)
-    
+    log.Info("Process monitoring configured", "collect_every", processCollectionInterval, "send_every", processSendInterval)
+    log.Debug("For testing, set PROCESS_COLLECTION_INTERVAL and PROCESS_SEND_INTERVAL env vars (in seconds)")
+
+    // Open ports scans, checked against the local port policy until the API sends one
+    ports := newPortTrackers()
+
     // TODO:
     // Disks should be configured on agent boot for defaults
     // e.g just send all disks
     // then updates from api if need be.
 
     //defaultDisks := []string{"/", "/home"}
-    defaultDisks := monitors.GetTopUsedDisks(2)
-    defaultServices := []string{"sshd", "monitor-monkey"} // linux defaults again can be configured
+    config := &monitoredConfig{
+        disks:      monitors.GetTopUsedDisks(2),
+        services:   []string{"sshd", "monitor-monkey"}, // linux defaults again can be configured
+        portPolicy: ports.policy,
+    }
+    status.SetConfigVersion(configVersion(config.get()))
 
     // Fetch configuration from API if it's configured
     // This is so we don't send 1 instance of non custom conf
-    // Prepare the request payload with host details
     // Retrieve host details
     Hostid, Hostname, Uptime, Os, Platform, Ip := monitors.GetHostDetails()
 
@@ -360,50 +934,55 @@ func main() {
         "Ip":       Ip,
     }
 
-    jsonPayload, err := json.Marshal(hostDetails)
-    if err != nil {
-        log(err)
+    // Host details tag everything sent to the metrics sinks
+    hostInfo := exporters.HostInfo{
+        Hostid:   Hostid,
+        Hostname: Hostname,
+        Os:       Os,
+        Platform: Platform,
+        Ip:       Ip,
+        AgentVer: AgentVersion,
     }
 
-    // Create a POST request with host details
-    req, err := http.NewRequest("POST", confApi, bytes.NewBuffer(jsonPayload))
+    // Spread this host's periodic sends out from the rest of the fleet
+    sched, err := schedule.NewFromEnv(Hostid)
     if err != nil {
-        log(err)
-    } else {
-        req.Header.Set("Authorization", authHeader)
-        req.Header.Set("Content-Type", "application/json")
+        log.Error("Ignoring invalid schedule settings", "err", err)
+    }
 
-        resp, err := client.Do(req)
-        if err != nil {
-            log(err)
+    // Every record goes through the dispatcher, the API is a sink unless turned off
+    dispatcher := sinks.NewDispatcher()
+    var api *sinks.API
+    if localOnly {
+        // Nothing leaves the host, every record is printed instead.
+        // A large buffer so --once never drops any of its burst of records.
+        dispatcher.Add(sinks.NewPrinter(os.Stdout), sinks.Options{BufferSize: 1000})
+    } else {
+        if apiEnabled {
+            api = sinks.NewAPI(client, baseURL, authHeader, config.apply)
+            addSink(dispatcher, api)
         } else {
-            defer resp.Body.Close()
-            body, err := io.ReadAll(resp.Body)
-            if err != nil {
-                log(err)
-            } else {
-                var confResponse map[string]interface{}
-                err = json.Unmarshal(body, &confResponse)
-                if err != nil {
-                    log(err)
-                } else {
-                    if value, ok := confResponse["message"]; ok && value == "noconf" {
-                        fmt.Println("No configuration changes needed.")
-                    } else {
-                        var custom Custom
-                        err = json.Unmarshal(body, &custom)
-                        if err != nil {
-                            log(err)
-                        }
-                        if custom.Disks != nil {
-                            defaultDisks = custom.Disks
-                        }
-                        if custom.Services != nil {
-                            defaultServices = custom.Services
-                        }
-                    }
-                }
-            }
+            log.Info("Monitor Monkey API disabled, only sending to other sinks")
+        }
+        if prometheus != nil {
+            addSink(dispatcher, prometheus)
+        }
+        addOutputSinks(dispatcher, hostInfo, !apiEnabled)
+    }
+
+    dispatcher.Start()
+    status.SetSinkReporter(dispatcher.Status)
+    stopOnSignal(dispatcher)
+
+    // Start process data collection in a goroutine
+    stopProcessCollection := make(chan struct{})
+    if !*onceFlag {
+        go collectProcessData(processCollectionInterval, stopProcessCollection, dispatcher, sched)
+    }
+
+    if api != nil {
+        if err := fetchConfig(api, hostDetails, config); err != nil {
+            log.Error("Failed to fetch configuration", "err", err)
         }
     }
 
@@ -411,27 +990,35 @@ func main() {
     interval := 5
 
     // Get initial network stats to establish a baseline
-    initialUpload, initialDownload := monitors.GetNetStats()
-
-    var oldUpload, oldDownload uint64 = 0, 0
-    oldUpload, oldDownload = initialUpload, initialDownload
+    netFilter, err := monitors.NetFilterFromEnv()
+    if err != nil {
+        log.Error("Ignoring invalid network interface settings", "err", err)
+        netFilter = monitors.DefaultNetFilter()
+    }
+    trackers := newCounterTrackers(netFilter)
 
-    fmt.Println("Initializing network monitoring... waiting for first interval")
+    log.Info("Initializing network monitoring, waiting for first interval")
     time.Sleep(time.Duration(interval) * time.Second)
 
+    if *onceFlag {
+        os.Exit(runOnce(dispatcher, config, Hostid, sched, netFilter, trackers, ports))
+    }
+
     // Check endpoint with a controlled number of retries
-    isAlive := false
-    for i := 0; i < 3; i++ { // Limit retries to avoid resource exhaustion
-        if helpers.CheckEndpoint(updateApi) {
-            fmt.Println("The endpoint is alive")
-            isAlive = true
-            break
+    if api != nil {
+        isAlive := false
+        for i := 0; i < 3; i++ { // Limit retries to avoid resource exhaustion
+            if helpers.CheckEndpoint(api.UpdateURL()) {
+                log.Info("The endpoint is alive")
+                isAlive = true
+                break
+            }
+            time.Sleep(time.Second * 5)
+        }
+        
+        if !isAlive {
+            log.Warn("Endpoint check failed, but continuing operation")
         }
-        time.Sleep(time.Second * 5)
-    }
-    
-    if !isAlive {
-        fmt.Println("Warning: Endpoint check failed, but continuing operation")
     }
     
     // Force garbage collection before entering main loop
@@ -440,145 +1027,111 @@ func main() {
     // Set up monitoring intervals
     portsCheckInterval := 24 * time.Hour
     
-    // Create tickers for periodic tasks
-    portsTicker := time.NewTicker(portsCheckInterval)
-    processesTicker := time.NewTicker(processSendInterval)
+    // Create tickers for periodic tasks, each first ticks after this host's splay
+    // so the startup sends don't all land on the server at once
+    portsTicker := sched.NewTicker("ports", portsCheckInterval)
+    processesTicker := sched.NewTicker("processes", processSendInterval)
+    interfacesTicker := sched.NewTicker("interfaces", portsCheckInterval)
+    log.Info("Startup sends splayed", "ports", sched.Splay("ports"), "processes", sched.Splay("processes"),
+        "interfaces", sched.Splay("interfaces"), "custom_alerts", sched.Splay("custom_alerts"))
+
+    // Ports are scanned often for changes, the full list is only sent daily
+    portScanInterval, err := events.PortScanIntervalFromEnv()
+    if err != nil {
+        log.Error("Ignoring invalid port scan interval", "err", err)
+    }
+    var portScanTicks <-chan time.Time // never ready when turned off
+    if portScanInterval > 0 {
+        portScanTicks = sched.NewTicker("port_scan", portScanInterval).C
+    }
+
+    // Connection table summaries, unless turned off
+    connectionOpts, err := events.ConnectionOptionsFromEnv()
+    if err != nil {
+        log.Error("Ignoring invalid connections settings", "err", err)
+    }
+    connectionWatcher := events.NewConnectionWatcher(connectionOpts)
+    var connectionsTicks <-chan time.Time // never ready when turned off
+    if connectionOpts.Interval > 0 {
+        connectionsTicks = sched.NewTicker("connections", connectionOpts.Interval).C
+    }
+
+    // Link, speed and address changes are checked on their own interval, the first check is the baseline
+    interfaceCheckInterval, err := events.InterfaceCheckIntervalFromEnv()
+    if err != nil {
+        log.Error("Ignoring invalid interface check interval", "err", err)
+    }
+    interfaceWatcher := events.NewInterfaceWatcher(netFilter)
+    var interfaceCheckTicks <-chan time.Time // never ready when turned off
+    if interfaceCheckInterval > 0 {
+        collect("interfaces", func() error {
+            _, err := interfaceWatcher.Check()
+            return err
+        })
+        interfaceCheckTicks = sched.NewTicker("interface_check", interfaceCheckInterval).C
+    }
     
     // Initialize custom alerts monitor
-    alertMonitor := custom.NewAlertMonitor(client, baseURL, authHeader, Hostid)
+    alertMonitor := custom.NewAlertMonitor(dispatcher.Publish, Hostid, sched)
     alertMonitor.Start()
+
+    // Control socket for `monitor-monkey-agent ctl`
+    if path := control.SocketPath(); path != "" {
+        startControlSocket(path, dispatcher, api, hostDetails, config, alertMonitor, ports, Hostid)
+    }
     
-    // Run open ports check immediately once at startup
-    go sendOpenPortsEvent(client, baseURL, authHeader)
-    
-    // Collect and send initial process data immediately at startup
-    fmt.Println("Collecting initial process data...")
-    err = events.CollectProcesses(10) // Collect top 10
+    // Snapshot the top processes when load, memory or iowait cross their thresholds
+    incidentOpts, err := events.IncidentOptionsFromEnv()
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Error collecting initial process data: %v\n", err)
-    } else {
-        fmt.Println("Sending initial process data...")
-        go sendProcessesEvents(client, baseURL, authHeader) // Send in a goroutine to avoid blocking startup
+        log.Error("Ignoring invalid incident settings", "err", err)
+    }
+    incidentWatcher := events.NewIncidentWatcher(incidentOpts)
+
+    // Compare the host clock with the server's on every update
+    skewChecker, err := clock.NewCheckerFromEnv()
+    if err != nil {
+        log.Error("Ignoring invalid clock skew settings", "err", err)
     }
 
     // Main monitoring loop
     for {
-        // Create maps each iteration
-        loadmap := make(map[string]float64)
-        diskmap := make(map[string]float64)
-        servicemap := make(map[string]string)
-
-        m := mesure{}
-        heartbeat := time.Now().Unix()
-        m.Heartbeat = heartbeat
-
-        m.Hostid, m.Hostname, m.Uptime, m.Os, m.Platform, m.Ip = monitors.GetHostDetails()
-        m.Temp = monitors.GetTemp()
-        m.Load = monitors.GetLoad(loadmap)
-
-        for _, disk := range defaultDisks {
-            diskmap[disk] = monitors.GetDiskUsage(disk)
-        }
-        m.Disks = diskmap
-        m.Memory = monitors.GetMem()
-        m.Upload, m.Download = monitors.GetNetStats()
-        m.AgentVer = AgentVersion
-        
-        m.UploadInterval = m.Upload - oldUpload
-        m.DownloadInterval = m.Download - oldDownload
-        
-        for _, service := range defaultServices {
-            servicemap[service] = monitors.ServiceCheck(service)
+        disks, services := config.get()
+        status.RecordCycle()
+        m := collectMesure(disks, services, trackers)
+        if event := skewChecker.Apply(m); event != nil {
+            dispatcher.Publish(payload.NewEventRecord(Hostid, "clock_skew", event))
         }
-        m.Services = servicemap
 
-        jsonBytes, err := json.Marshal(m)
-        if err != nil {
-            log(err)
-            time.Sleep(time.Duration(interval) * time.Second)
-            continue
-        }
+        // Hand the cycle to every sink, delivery happens in the background
+        dispatcher.Publish(payload.NewMesureRecord(m))
 
-        // Create and send the request
-        req, err := http.NewRequest("POST", updateApi, bytes.NewBuffer(jsonBytes))
-        if err != nil {
-            log(err)
-            time.Sleep(time.Duration(interval) * time.Second)
-            continue
+        if triggers := incidentWatcher.Check(incidentMetrics(m)); len(triggers) > 0 {
+            go sendIncidentSnapshot(dispatcher, Hostid, triggers)
         }
-        
-        req.Header.Set("Content-Type", "application/json")
-        req.Header.Set("Authorization", authHeader)
 
-        resp, err := client.Do(req)
-        if err != nil {
-            log(err)
-            time.Sleep(time.Duration(interval) * time.Second)
-            continue
+        // Trigger garbage collection periodically
+        if m.Heartbeat % 60 == 0 {  // Every minute
+            debug.FreeOSMemory()
         }
         
-        // Always close the body to prevent resource leaks
-        body, err := io.ReadAll(resp.Body)
-        resp.Body.Close() // Explicitly close rather than defer to avoid accumulation
-
-        if err != nil {
-            log(err)
-            time.Sleep(time.Duration(interval) * time.Second)
-            continue
-        }
-
-        // Unmarshal into responseMap
-        var responseMap map[string]interface{}
-        err = json.Unmarshal(body, &responseMap)
-        if err != nil {
-            log(err)
-            time.Sleep(time.Duration(interval) * time.Second)
-            continue
+        // Check if it's time to send events (non-blocking)
+        select {
+        case <-portsTicker.C:
+            go sendOpenPortsEvent(dispatcher, Hostid, ports)
+        case <-portScanTicks:
+            go scanOpenPorts(dispatcher, Hostid, ports)
+        case <-processesTicker.C:
+            go sendProcessesEvents(dispatcher, Hostid)
+        case <-interfacesTicker.C:
+            go sendInterfacesEvent(dispatcher, Hostid, netFilter)
+        case <-interfaceCheckTicks:
+            go checkInterfaces(dispatcher, Hostid, interfaceWatcher)
+        case <-connectionsTicks:
+            go sendConnectionsEvent(dispatcher, Hostid, connectionWatcher)
+        default:
+            // Continue with the main loop
         }
 
-        // Check for "tomany" message
-        if value, ok := responseMap["message"]; ok && value == "tomany" {
-            fmt.Println("You have too many hosts being monitored for your payment plan")
-            fmt.Println("Please remove some hosts or purchase some more :)")
-            fmt.Println("I'll now go to sleep for a while 😪😪")
-            time.Sleep(60 * time.Second)
-        } else {
-            // Unmarshal into custom struct
-            var custom Custom
-            err = json.Unmarshal(body, &custom)
-            if err != nil {
-                log(err)
-            }
-            if custom.Disks != nil {
-                defaultDisks = custom.Disks
-            }
-            if custom.Services != nil {
-                defaultServices = custom.Services
-            }
-
-            oldUpload = m.Upload
-            oldDownload = m.Download
-
-            // Explicitly clear out old data structures to help garbage collection
-            body = nil
-            jsonBytes = nil
-            
-            // Trigger garbage collection periodically
-            if heartbeat % 60 == 0 {  // Every minute
-                debug.FreeOSMemory()
-            }
-            
-            // Check if it's time to send events (non-blocking)
-            select {
-            case <-portsTicker.C:
-                go sendOpenPortsEvent(client, baseURL, authHeader)
-            case <-processesTicker.C:
-                go sendProcessesEvents(client, baseURL, authHeader)
-            default:
-                // Continue with the main loop
-            }
-
-            time.Sleep(time.Duration(interval) * time.Second)
-        }
+        time.Sleep(time.Duration(interval) * time.Second)
     }
 }
@@ -124,12 +124,12 @@ func isSpecialFS(fstype string) bool {
            strings.Contains(fstype, "snap")      // Catch any snap-related filesystems
 }
 
-func GetDiskUsage(diskPath string) float64 {
+func GetDiskUsage(diskPath string) (float64, error) {
     diskStat, err := disk.Usage(diskPath)
     if err != nil {
-        return 0.0
+        return 0.0, err
     }
-    return diskStat.UsedPercent
+    return diskStat.UsedPercent, nil
 }
 
 func GetDiskSize(diskPath string) uint64 {
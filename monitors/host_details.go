@@ -5,20 +5,23 @@
 package monitors
 
 import (
-    "fmt"
     "github.com/shirou/gopsutil/v3/host"
-    "log"
+    "go_monitor/logger"
     "net"
-
+    "os"
+    "strings"
 )
 
+var log = logger.For("monitors")
+
 // Get preferred outbound ip of this machine
 // does not make any connections
 // https://stackoverflow.com/questions/23558425/how-do-i-get-the-local-ip-address-in-go
 func getOutboundIP() net.IP {
     conn, err := net.Dial("udp", "8.8.8.8:80")
     if err != nil {
-        log.Fatal(err)
+        log.Error("Failed to determine outbound IP", "err", err)
+        os.Exit(1)
     }
     defer conn.Close()
 
@@ -35,7 +38,7 @@ func GetHostDetails() (string, string, uint64, string, string, string) {
 */
     info, err := host.Info()
     if err != nil {
-    fmt.Println(err)
+    log.Error("Failed to read host info", "err", err)
     }
     //fmt.Println(info)
 
@@ -54,3 +57,12 @@ func GetHostDetails() (string, string, uint64, string, string, string) {
     fmt.Printf("IP: %v\n", getOutboundIP())
     */
 }
+
+// GetBootID returns the kernel's ID for the current boot, it changes every time the host boots
+func GetBootID() (string, error) {
+    id, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSpace(string(id)), nil
+}
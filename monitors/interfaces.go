@@ -0,0 +1,91 @@
+// interfaces.go
+// inventories the network interfaces: addresses, MAC, MTU and link state, speed and duplex
+
+package monitors
+
+import (
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// InterfaceInfo describes a network interface as it is now
+type InterfaceInfo struct {
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Counted   bool     `json:"counted"` // whether its traffic is in the host's totals
+	MAC       string   `json:"mac,omitempty"`
+	MTU       int      `json:"mtu"`
+	OperState string   `json:"operstate"`            // up, down, dormant, lowerlayerdown or unknown
+	Carrier   *bool    `json:"carrier,omitempty"`    // unknown while the interface is administratively down
+	SpeedMbps int      `json:"speed_mbps,omitempty"` // unknown while down and for most virtual devices
+	Duplex    string   `json:"duplex,omitempty"`
+	Addresses []string `json:"addresses"` // CIDR notation, sorted
+}
+
+// Speed describes the negotiated speed and duplex, e.g. "1000Mb/s full"
+func (i InterfaceInfo) Speed() string {
+	if i.SpeedMbps == 0 {
+		return "unknown"
+	}
+	speed := strconv.Itoa(i.SpeedMbps) + "Mb/s"
+	if i.Duplex != "" {
+		speed += " " + i.Duplex
+	}
+	return speed
+}
+
+// GetInterfaces returns every interface sorted by name, each with its kind and
+// whether filter counts its traffic
+func GetInterfaces(filter NetFilter) ([]InterfaceInfo, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []InterfaceInfo
+	for _, iface := range ifaces {
+		kind := InterfaceKind(iface.Name)
+		info := InterfaceInfo{
+			Name:      iface.Name,
+			Kind:      kind,
+			Counted:   filter.Counts(iface.Name, kind),
+			MAC:       iface.HardwareAddr.String(),
+			MTU:       iface.MTU,
+			OperState: "unknown",
+			Addresses: []string{},
+		}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				info.Addresses = append(info.Addresses, addr.String())
+			}
+			sort.Strings(info.Addresses)
+		}
+
+		// Link details only come from /sys, elsewhere the state is just up or down
+		dir := filepath.Join(sysClassNet, iface.Name)
+		if state := readSysValue(dir, "operstate"); state != "" {
+			info.OperState = state
+		} else if iface.Flags&net.FlagUp != 0 {
+			info.OperState = "up"
+		} else {
+			info.OperState = "down"
+		}
+		// reading carrier or speed fails while the interface is down
+		if carrier := readSysValue(dir, "carrier"); carrier != "" {
+			up := carrier == "1"
+			info.Carrier = &up
+		}
+		if speed, err := strconv.Atoi(readSysValue(dir, "speed")); err == nil && speed > 0 {
+			info.SpeedMbps = speed
+		}
+		if duplex := readSysValue(dir, "duplex"); duplex != "" && duplex != "unknown" {
+			info.Duplex = duplex
+		}
+
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Name < infos[b].Name })
+	return infos, nil
+}
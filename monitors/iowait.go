@@ -1,15 +1,77 @@
+// iowait.go
+// gets the share of CPU time spent waiting on IO from the totals in /proc/stat
+
 package monitors
 
 import (
+	"bufio"
 	"fmt"
-    "syscall"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-func GetIOWait() {
-    var info syscall.Sysinfo_t
-    if err := syscall.Sysinfo(&info); err != nil {
-        panic(err)
-    }
-    fmt.Printf("IOWait: %d\n", info.Uptime)
+// IOWaitTracker keeps the previous CPU time totals so a reading covers the time between two
+type IOWaitTracker struct {
+	mutex      sync.Mutex
+	lastIOWait uint64
+	lastTotal  uint64
+}
+
+// NewIOWaitTracker creates a tracker, the first reading only sets the baseline
+func NewIOWaitTracker() *IOWaitTracker {
+	return &IOWaitTracker{}
+}
+
+// Read returns the percentage of CPU time spent in iowait since the previous reading,
+// ok is false on the first reading or when no time has passed
+func (t *IOWaitTracker) Read() (percent float64, ok bool, err error) {
+	iowait, total, err := readCPUTimes()
+	if err != nil {
+		return 0, false, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.lastTotal > 0 && total > t.lastTotal && iowait >= t.lastIOWait {
+		percent = float64(iowait-t.lastIOWait) / float64(total-t.lastTotal) * 100
+		ok = true
+	}
+	t.lastIOWait, t.lastTotal = iowait, total
+	return percent, ok, nil
 }
 
+// readCPUTimes reads the iowait and total CPU time of the "cpu" line of /proc/stat,
+// "cpu user nice system idle iowait irq softirq steal guest guest_nice". Guest time
+// is already counted in user and nice, so it's left out of the total.
+func readCPUTimes() (iowait, total uint64, err error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[0] != "cpu" {
+			continue
+		}
+		for i, field := range fields[1:min(len(fields), 9)] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid /proc/stat cpu time %q: %w", field, err)
+			}
+			total += value
+			if i == 4 {
+				iowait = value
+			}
+		}
+		return iowait, total, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, fmt.Errorf("no cpu line in /proc/stat")
+}
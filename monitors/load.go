@@ -5,15 +5,14 @@
 package monitors
 
 import (
-    "fmt"
     "github.com/shirou/gopsutil/v3/load"
 )
 
-func GetLoad(loadmap map[string]float64) map[string]float64 {
+func GetLoad(loadmap map[string]float64) (map[string]float64, error) {
 
  	load, err := load.Avg()
 	if err != nil {
-		fmt.Println(err)
+		return loadmap, err
 	}
 
     //fmt.Println(load)
@@ -25,7 +24,7 @@ func GetLoad(loadmap map[string]float64) map[string]float64 {
     loadmap["load1"] = load.Load1
     loadmap["load5"] = load.Load5
     loadmap["load15"] = load.Load15
-    return loadmap
+    return loadmap, nil
 }
 /*
 func GetPs() {
@@ -4,18 +4,17 @@
 package monitors
 
 import (
-    "fmt"
     "github.com/shirou/gopsutil/v3/mem"
 )
 
-func GetMem() float64 {
+func GetMem() (float64, error) {
 
  	memory, err := mem.VirtualMemory()
 	if err != nil {
-		fmt.Println(err)
+		return 0, err
 	}
 
     //fmt.Println(load.Load1)
-    return memory.UsedPercent
+    return memory.UsedPercent, nil
     // Example on how to get specifc value (loop over it durrr)
 }
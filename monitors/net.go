@@ -1,33 +1,154 @@
 package monitors
 
 import (
-    "fmt"
     "github.com/shirou/gopsutil/v3/net"
-    "strings"
+    "math"
+    "sync"
+    "time"
 )
 
-func GetNetStats() (uint64, uint64) {
+// NetStats is one reading of the network counters, summed over the counted interfaces.
+// Intervals and rates cover the time since the previous reading and are zero on the first.
+type NetStats struct {
+    Upload           uint64  // bytes sent, as the interfaces count them
+    Download         uint64
+    UploadInterval   uint64  // bytes sent since the previous reading
+    DownloadInterval uint64
+    UploadRate       float64 // bytes/sec
+    DownloadRate     float64
+    PacketsSentRate  float64 // packets/sec
+    PacketsRecvRate  float64
+    Interfaces       map[string]InterfaceStats
+}
+
+// InterfaceStats is a single interface's traffic since the previous reading
+type InterfaceStats struct {
+    Kind             string
+    UploadInterval   uint64
+    DownloadInterval uint64
+    UploadRate       float64 // bytes/sec
+    DownloadRate     float64
+    PacketsSent      uint64
+    PacketsRecv      uint64
+    PacketsSentRate  float64 // packets/sec
+    PacketsRecvRate  float64
+    ErrorsIn         uint64
+    ErrorsOut        uint64
+    DropsIn          uint64
+    DropsOut         uint64
+}
+
+// NetTracker keeps each interface's previous counters so a reading can be turned into
+// deltas. A counter that goes backwards was reset or wrapped, it never gives a negative
+// delta, and interfaces that appear or disappear don't move the totals.
+type NetTracker struct {
+    filter   NetFilter
+    mutex    sync.Mutex
+    kinds    map[string]string
+    last     map[string]net.IOCountersStat
+    lastTime time.Time
+}
+
+// NewNetTracker creates a tracker counting the interfaces filter selects,
+// the first reading only sets the baseline
+func NewNetTracker(filter NetFilter) *NetTracker {
+    return &NetTracker{filter: filter, kinds: make(map[string]string)}
+}
+
+// Read takes a reading of the network counters
+func (t *NetTracker) Read() (NetStats, error) {
+    stats := NetStats{Interfaces: make(map[string]InterfaceStats)}
+
     // Get stats for all interfaces (true = per interface)
     nstats, err := net.IOCounters(true)
     if err != nil {
-        fmt.Println(err)
-        return 0, 0
+        return stats, err
     }
+    now := time.Now()
 
-    var total_upload uint64 = 0
-    var total_download uint64 = 0
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
 
-    // Iterate through all interfaces and sum up the stats
-    // Skip the loopback interface (typically named "lo")
+    // Rates use the real time between readings, not the loop interval
+    var elapsed float64
+    if !t.lastTime.IsZero() {
+        elapsed = now.Sub(t.lastTime).Seconds()
+    }
+    rate := func(delta uint64) float64 {
+        if elapsed <= 0 {
+            return 0
+        }
+        return float64(delta) / elapsed
+    }
+
+    var packetsSent, packetsRecv uint64
+    current := make(map[string]net.IOCountersStat, len(nstats))
+    kinds := make(map[string]string, len(nstats))
     for _, stat := range nstats {
-        // Skip loopback interface (usually named "lo" on Linux, "lo0" on macOS)
-        if strings.Contains(strings.ToLower(stat.Name), "lo") {
+        // An interface's kind doesn't change, only look it up when it first appears
+        kind, ok := t.kinds[stat.Name]
+        if !ok {
+            kind = InterfaceKind(stat.Name)
+        }
+        kinds[stat.Name] = kind
+        if !t.filter.Counts(stat.Name, kind) {
             continue
         }
-        
-        total_upload += stat.BytesSent
-        total_download += stat.BytesRecv
+        current[stat.Name] = stat
+
+        stats.Upload += stat.BytesSent
+        stats.Download += stat.BytesRecv
+
+        // a new interface only sets its baseline
+        iface := InterfaceStats{Kind: kind}
+        if prev, ok := t.last[stat.Name]; ok {
+            iface.UploadInterval = counterDelta(stat.Name, "bytes_sent", prev.BytesSent, stat.BytesSent)
+            iface.DownloadInterval = counterDelta(stat.Name, "bytes_recv", prev.BytesRecv, stat.BytesRecv)
+            iface.PacketsSent = counterDelta(stat.Name, "packets_sent", prev.PacketsSent, stat.PacketsSent)
+            iface.PacketsRecv = counterDelta(stat.Name, "packets_recv", prev.PacketsRecv, stat.PacketsRecv)
+            iface.ErrorsIn = counterDelta(stat.Name, "errin", prev.Errin, stat.Errin)
+            iface.ErrorsOut = counterDelta(stat.Name, "errout", prev.Errout, stat.Errout)
+            iface.DropsIn = counterDelta(stat.Name, "dropin", prev.Dropin, stat.Dropin)
+            iface.DropsOut = counterDelta(stat.Name, "dropout", prev.Dropout, stat.Dropout)
+            iface.UploadRate = rate(iface.UploadInterval)
+            iface.DownloadRate = rate(iface.DownloadInterval)
+            iface.PacketsSentRate = rate(iface.PacketsSent)
+            iface.PacketsRecvRate = rate(iface.PacketsRecv)
+        }
+        stats.Interfaces[stat.Name] = iface
+
+        stats.UploadInterval += iface.UploadInterval
+        stats.DownloadInterval += iface.DownloadInterval
+        packetsSent += iface.PacketsSent
+        packetsRecv += iface.PacketsRecv
     }
+    stats.UploadRate = rate(stats.UploadInterval)
+    stats.DownloadRate = rate(stats.DownloadInterval)
+    stats.PacketsSentRate = rate(packetsSent)
+    stats.PacketsRecvRate = rate(packetsRecv)
 
-    return total_upload, total_download
+    t.kinds = kinds
+    t.last = current
+    t.lastTime = now
+    return stats, nil
+}
+
+// counterDelta is how far a counter moved between readings. Some drivers still keep
+// 32 bit counters, one that passes 2^32 comes back round to a small number. A counter
+// that went backwards any other way was reset, everything it has counted is new,
+// source is the interface or protocol the counter belongs to.
+func counterDelta(source, counter string, prev, cur uint64) uint64 {
+    if cur >= prev {
+        return cur - prev
+    }
+    if prev <= math.MaxUint32 {
+        wrapped := math.MaxUint32 - prev + cur + 1
+        // a wrap only explains small moves, anything bigger is a reset
+        if wrapped <= math.MaxUint32/2 {
+            log.Debug("Network counter wrapped", "source", source, "counter", counter)
+            return wrapped
+        }
+    }
+    log.Info("Network counter reset", "source", source, "counter", counter, "previous", prev, "current", cur)
+    return cur
 }
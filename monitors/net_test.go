@@ -0,0 +1,30 @@
+package monitors
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur uint64
+		want      uint64
+	}{
+		{"unchanged", 1000, 1000, 0},
+		{"moved", 1000, 1500, 500},
+		{"from zero", 0, 42, 42},
+		{"64 bit counter moved", 1 << 40, 1<<40 + 10, 10},
+		{"32 bit counter wrapped", math.MaxUint32 - 99, 100, 200},
+		{"32 bit counter wrapped to zero", math.MaxUint32, 0, 1},
+		{"32 bit counter wrapped from halfway", 3_000_000_000, 5, 1_294_967_301},
+		{"32 bit counter reset", 1_000_000_000, 5, 5},
+		{"64 bit counter reset", 1 << 40, 7, 7},
+		{"reset to zero", 500, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := counterDelta("eth0", "bytes_recv", tt.prev, tt.cur); got != tt.want {
+			t.Errorf("%s: counterDelta(%d, %d) = %d, want %d", tt.name, tt.prev, tt.cur, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// netif.go
+// decides which network interfaces count towards the host's traffic. Interfaces are
+// told apart by what they are rather than their names, so wlo1 and eno1 are counted
+// while the bridges and veth pairs that carry the same packets again are not.
+
+package monitors
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// Environment variables selecting the interfaces to count
+const (
+	NetIncludeEnvVar      = "MONKEY_NET_INCLUDE"       // comma separated globs, only matching interfaces are counted
+	NetExcludeEnvVar      = "MONKEY_NET_EXCLUDE"       // comma separated globs, matching interfaces are never counted
+	NetExcludeTypesEnvVar = "MONKEY_NET_EXCLUDE_TYPES" // interface types not counted, default loopback,bridge,veth,virtual,bond,vlan
+)
+
+// Interface types
+const (
+	IfaceLoopback = "loopback"
+	IfaceBridge   = "bridge"
+	IfaceVeth     = "veth"
+	IfaceTun      = "tun"
+	IfaceBond     = "bond"
+	IfaceVLAN     = "vlan"
+	IfaceWireless = "wireless"
+	IfaceVirtual  = "virtual" // any other software device, e.g. dummy or ifb
+	IfacePhysical = "physical"
+	IfaceUnknown  = "unknown" // no /sys/class/net, i.e. not Linux
+)
+
+// DefaultExcludeTypes repeat traffic already counted on another interface, or never leave the host.
+// Bonds and VLANs are upper devices, their packets are counted on the NICs underneath them,
+// a bond's slaves and a VLAN's parent, so the total is what went over the wire.
+var DefaultExcludeTypes = []string{IfaceLoopback, IfaceBridge, IfaceVeth, IfaceVirtual, IfaceBond, IfaceVLAN}
+
+const sysClassNet = "/sys/class/net"
+
+// NetFilter selects interfaces by name and type. An interface matching an include glob
+// is counted whatever its type, so e.g. a bridge can be opted in by name.
+type NetFilter struct {
+	Include      []string
+	Exclude      []string
+	ExcludeTypes map[string]bool
+}
+
+// NetFilterFromEnv reads MONKEY_NET_INCLUDE, MONKEY_NET_EXCLUDE and MONKEY_NET_EXCLUDE_TYPES.
+// MONKEY_NET_EXCLUDE_TYPES set to an empty string excludes no types.
+func NetFilterFromEnv() (NetFilter, error) {
+	f := NetFilter{
+		Include:      splitEnvList(os.Getenv(NetIncludeEnvVar)),
+		Exclude:      splitEnvList(os.Getenv(NetExcludeEnvVar)),
+		ExcludeTypes: make(map[string]bool),
+	}
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return NetFilter{}, fmt.Errorf("bad interface pattern %q: %w", pattern, err)
+		}
+	}
+
+	types := DefaultExcludeTypes
+	if env, ok := os.LookupEnv(NetExcludeTypesEnvVar); ok {
+		types = splitEnvList(env)
+	}
+	for _, t := range types {
+		f.ExcludeTypes[t] = true
+	}
+	return f, nil
+}
+
+// DefaultNetFilter counts every interface except the default excluded types
+func DefaultNetFilter() NetFilter {
+	f := NetFilter{ExcludeTypes: make(map[string]bool)}
+	for _, t := range DefaultExcludeTypes {
+		f.ExcludeTypes[t] = true
+	}
+	return f
+}
+
+// Counts reports whether an interface's traffic is counted
+func (f NetFilter) Counts(name, kind string) bool {
+	if matchAny(f.Exclude, name) {
+		return false
+	}
+	if len(f.Include) > 0 {
+		return matchAny(f.Include, name)
+	}
+	return !f.ExcludeTypes[kind]
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func splitEnvList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// InterfaceKind works out what kind of device an interface is from /sys/class/net
+func InterfaceKind(name string) string {
+	dir := filepath.Join(sysClassNet, name)
+	if _, err := os.Stat(dir); err != nil {
+		return kindFromFlags(name)
+	}
+
+	if readSysValue(dir, "type") == "772" { // ARPHRD_LOOPBACK
+		return IfaceLoopback
+	}
+	switch {
+	case sysExists(dir, "bridge"):
+		return IfaceBridge
+	case sysExists(dir, "tun_flags"):
+		return IfaceTun
+	case sysExists(dir, "bonding"):
+		return IfaceBond
+	case sysExists(dir, "wireless"), sysExists(dir, "phy80211"):
+		return IfaceWireless
+	}
+	switch ueventDevType(dir) {
+	case "vlan":
+		return IfaceVLAN
+	case "wlan":
+		return IfaceWireless
+	}
+	if sysExists(dir, "device") {
+		return IfacePhysical
+	}
+
+	// Software devices live under /sys/devices/virtual, veth pairs point at their peer
+	target, err := filepath.EvalSymlinks(dir)
+	if err != nil || !strings.Contains(target, "/devices/virtual/") {
+		return IfaceUnknown
+	}
+	if iflink := readSysValue(dir, "iflink"); iflink != "" && iflink != readSysValue(dir, "ifindex") {
+		return IfaceVeth
+	}
+	return IfaceVirtual
+}
+
+// kindFromFlags can only spot loopback, for systems without /sys/class/net
+func kindFromFlags(name string) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return IfaceUnknown
+	}
+	for _, iface := range ifaces {
+		if iface.Name != name {
+			continue
+		}
+		for _, flag := range iface.Flags {
+			if flag == "loopback" {
+				return IfaceLoopback
+			}
+		}
+	}
+	return IfaceUnknown
+}
+
+func sysExists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
+
+func readSysValue(dir, name string) string {
+	value, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// ueventDevType returns the DEVTYPE the kernel reports for the device, if any
+func ueventDevType(dir string) string {
+	for _, line := range strings.Split(readSysValue(dir, "uevent"), "\n") {
+		if value, ok := strings.CutPrefix(line, "DEVTYPE="); ok {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package monitors
+
+import "testing"
+
+func TestNetFilterCounts(t *testing.T) {
+	defaults := DefaultNetFilter()
+	tests := []struct {
+		name   string
+		filter NetFilter
+		iface  string
+		kind   string
+		want   bool
+	}{
+		{"physical NIC", defaults, "eno1", IfacePhysical, true},
+		{"wireless", defaults, "wlo1", IfaceWireless, true},
+		{"tunnel", defaults, "wg0", IfaceTun, true},
+		{"loopback", defaults, "lo", IfaceLoopback, false},
+		{"bridge", defaults, "docker0", IfaceBridge, false},
+		{"veth", defaults, "veth1a2b3c", IfaceVeth, false},
+		{"bond counted on its slaves", defaults, "bond0", IfaceBond, false},
+		{"VLAN counted on its parent", defaults, "eth0.100", IfaceVLAN, false},
+		{"included by name whatever the type", NetFilter{Include: []string{"bond*"}}, "bond0", IfaceBond, true},
+		{"not in the include list", NetFilter{Include: []string{"bond*"}}, "eth0", IfacePhysical, false},
+		{"excluded by name", NetFilter{Exclude: []string{"tailscale*"}, ExcludeTypes: defaults.ExcludeTypes},
+			"tailscale0", IfaceTun, false},
+		{"exclude wins over include", NetFilter{Include: []string{"eth*"}, Exclude: []string{"eth1"}},
+			"eth1", IfacePhysical, false},
+		{"no types excluded", NetFilter{ExcludeTypes: map[string]bool{}}, "docker0", IfaceBridge, true},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Counts(tt.iface, tt.kind); got != tt.want {
+			t.Errorf("%s: Counts(%q, %q) = %v, want %v", tt.name, tt.iface, tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestNetFilterFromEnv(t *testing.T) {
+	t.Setenv(NetIncludeEnvVar, " eth*, ,docker0")
+	t.Setenv(NetExcludeEnvVar, "")
+	t.Setenv(NetExcludeTypesEnvVar, "loopback")
+	f, err := NetFilterFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Include) != 2 || f.Include[0] != "eth*" || f.Include[1] != "docker0" {
+		t.Errorf("Include = %q", f.Include)
+	}
+	if len(f.ExcludeTypes) != 1 || !f.ExcludeTypes[IfaceLoopback] {
+		t.Errorf("ExcludeTypes = %v", f.ExcludeTypes)
+	}
+
+	t.Setenv(NetExcludeEnvVar, "eth[")
+	if _, err := NetFilterFromEnv(); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
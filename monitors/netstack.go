@@ -0,0 +1,200 @@
+// netstack.go
+// gets TCP and UDP health from the kernel's protocol counters, so retransmit
+// storms and overflowing listen queues show up and not just bytes moved
+
+package monitors
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files the kernel keeps its protocol counters and socket counts in
+const (
+	procNetSNMP     = "/proc/net/snmp"
+	procNetNetstat  = "/proc/net/netstat"
+	procNetSockstat = "/proc/net/sockstat"
+)
+
+// StackCounter is a kernel counter with how far it moved since the previous reading
+type StackCounter struct {
+	Total uint64
+	Delta uint64
+	Rate  float64 // per second
+}
+
+// NetStackStats is one reading of the TCP and UDP counters and socket counts
+type NetStackStats struct {
+	TCPRetransSegs     StackCounter // segments retransmitted
+	TCPOutSegs         StackCounter // segments sent, for the retransmit ratio
+	TCPRetransPercent  float64      // retransmitted segments as a percentage of those sent this interval
+	TCPOutRsts         StackCounter // resets sent
+	TCPEstabResets     StackCounter // established connections reset
+	TCPAttemptFails    StackCounter // connection attempts that failed
+	TCPInErrs          StackCounter // bad segments received
+	TCPListenOverflows StackCounter // connections refused because an accept queue was full
+	TCPListenDrops     StackCounter // SYNs dropped on a listening socket, including overflows
+	UDPInErrors        StackCounter // datagrams that couldn't be delivered
+	UDPRcvbufErrors    StackCounter // datagrams dropped because a receive buffer was full
+	UDPSndbufErrors    StackCounter
+	UDPNoPorts         StackCounter // datagrams to a port nothing listens on
+
+	// Socket counts at the time of the reading
+	SocketsUsed    uint64
+	TCPEstablished uint64
+	TCPInUse       uint64
+	TCPOrphan      uint64
+	TCPTimeWait    uint64
+	TCPAlloc       uint64
+	UDPInUse       uint64
+}
+
+// stackCounters maps each counter to its "<Protocol>.<Name>" in /proc/net/snmp or /proc/net/netstat
+var stackCounters = []struct {
+	key   string
+	field func(s *NetStackStats) *StackCounter
+}{
+	{"Tcp.RetransSegs", func(s *NetStackStats) *StackCounter { return &s.TCPRetransSegs }},
+	{"Tcp.OutSegs", func(s *NetStackStats) *StackCounter { return &s.TCPOutSegs }},
+	{"Tcp.OutRsts", func(s *NetStackStats) *StackCounter { return &s.TCPOutRsts }},
+	{"Tcp.EstabResets", func(s *NetStackStats) *StackCounter { return &s.TCPEstabResets }},
+	{"Tcp.AttemptFails", func(s *NetStackStats) *StackCounter { return &s.TCPAttemptFails }},
+	{"Tcp.InErrs", func(s *NetStackStats) *StackCounter { return &s.TCPInErrs }},
+	{"TcpExt.ListenOverflows", func(s *NetStackStats) *StackCounter { return &s.TCPListenOverflows }},
+	{"TcpExt.ListenDrops", func(s *NetStackStats) *StackCounter { return &s.TCPListenDrops }},
+	{"Udp.InErrors", func(s *NetStackStats) *StackCounter { return &s.UDPInErrors }},
+	{"Udp.RcvbufErrors", func(s *NetStackStats) *StackCounter { return &s.UDPRcvbufErrors }},
+	{"Udp.SndbufErrors", func(s *NetStackStats) *StackCounter { return &s.UDPSndbufErrors }},
+	{"Udp.NoPorts", func(s *NetStackStats) *StackCounter { return &s.UDPNoPorts }},
+}
+
+// NetStackTracker keeps the previous counters so a reading can be turned into deltas and rates
+type NetStackTracker struct {
+	mutex    sync.Mutex
+	last     map[string]uint64
+	lastTime time.Time
+}
+
+// NewNetStackTracker creates a tracker, the first reading only sets the baseline
+func NewNetStackTracker() *NetStackTracker {
+	return &NetStackTracker{}
+}
+
+// Read takes a reading of the protocol counters and socket counts
+func (t *NetStackTracker) Read() (NetStackStats, error) {
+	var stats NetStackStats
+
+	counters := make(map[string]uint64)
+	for _, file := range []string{procNetSNMP, procNetNetstat} {
+		if err := readProtoCounters(file, counters); err != nil {
+			return stats, err
+		}
+	}
+	now := time.Now()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var elapsed float64
+	if !t.lastTime.IsZero() {
+		elapsed = now.Sub(t.lastTime).Seconds()
+	}
+	for _, c := range stackCounters {
+		total, ok := counters[c.key]
+		if !ok {
+			// older kernels lack some counters
+			continue
+		}
+		counter := c.field(&stats)
+		counter.Total = total
+		if prev, ok := t.last[c.key]; ok {
+			counter.Delta = counterDelta("netstack", c.key, prev, total)
+			if elapsed > 0 {
+				counter.Rate = float64(counter.Delta) / elapsed
+			}
+		}
+	}
+	if stats.TCPOutSegs.Delta > 0 {
+		stats.TCPRetransPercent = float64(stats.TCPRetransSegs.Delta) / float64(stats.TCPOutSegs.Delta) * 100
+	}
+	stats.TCPEstablished = counters["Tcp.CurrEstab"]
+
+	t.last = counters
+	t.lastTime = now
+
+	return stats, readSockstat(procNetSockstat, &stats)
+}
+
+// readProtoCounters parses the header and value line pairs of /proc/net/snmp and
+// /proc/net/netstat into "<Protocol>.<Name>" keys
+func readProtoCounters(file string, counters map[string]uint64) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var header []string
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if header == nil || header[0] != fields[0] {
+			header = fields
+			continue
+		}
+		if len(fields) != len(header) {
+			return fmt.Errorf("unexpected %s: %s has %d values for %d names", file, fields[0], len(fields)-1, len(header)-1)
+		}
+		proto := strings.TrimSuffix(fields[0], ":")
+		for i := 1; i < len(fields); i++ {
+			// a few values, like Tcp MaxConn, can be -1, they aren't counters
+			if value, err := strconv.ParseUint(fields[i], 10, 64); err == nil {
+				counters[proto+"."+header[i]] = value
+			}
+		}
+		header = nil
+	}
+	return scanner.Err()
+}
+
+// readSockstat fills in the socket counts from /proc/net/sockstat, lines like
+// "TCP: inuse 4 orphan 0 tw 0 alloc 4 mem 0"
+func readSockstat(file string, stats *NetStackStats) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		values := make(map[string]uint64)
+		for i := 1; i+1 < len(fields); i += 2 {
+			if value, err := strconv.ParseUint(fields[i+1], 10, 64); err == nil {
+				values[fields[i]] = value
+			}
+		}
+		switch fields[0] {
+		case "sockets:":
+			stats.SocketsUsed = values["used"]
+		case "TCP:":
+			stats.TCPInUse = values["inuse"]
+			stats.TCPOrphan = values["orphan"]
+			stats.TCPTimeWait = values["tw"]
+			stats.TCPAlloc = values["alloc"]
+		case "UDP:":
+			stats.UDPInUse = values["inuse"]
+		}
+	}
+	return nil
+}
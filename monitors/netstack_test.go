@@ -0,0 +1,146 @@
+package monitors
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeProcFile writes content to a file in a temporary directory and returns its path
+func writeProcFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadProtoCounters(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]uint64
+		wantErr bool
+	}{
+		{
+			name: "snmp",
+			content: "Ip: Forwarding DefaultTTL InReceives\n" +
+				"Ip: 2 64 12967\n" +
+				"Tcp: RtoAlgorithm MaxConn ActiveOpens CurrEstab InErrs OutSegs RetransSegs\n" +
+				"Tcp: 1 -1 30 2 0 900 12\n" +
+				"Udp: InDatagrams NoPorts InErrors\n" +
+				"Udp: 10 3 1\n",
+			want: map[string]uint64{
+				"Ip.Forwarding": 2, "Ip.DefaultTTL": 64, "Ip.InReceives": 12967,
+				// MaxConn is -1, not a counter
+				"Tcp.RtoAlgorithm": 1, "Tcp.ActiveOpens": 30, "Tcp.CurrEstab": 2, "Tcp.InErrs": 0,
+				"Tcp.OutSegs": 900, "Tcp.RetransSegs": 12,
+				"Udp.InDatagrams": 10, "Udp.NoPorts": 3, "Udp.InErrors": 1,
+			},
+		},
+		{
+			name: "netstat",
+			content: "TcpExt: SyncookiesSent ListenOverflows ListenDrops\n" +
+				"TcpExt: 0 5 7\n" +
+				"IpExt: InNoRoutes InOctets\n" +
+				"IpExt: 0 18446744073709551615\n",
+			want: map[string]uint64{
+				"TcpExt.SyncookiesSent": 0, "TcpExt.ListenOverflows": 5, "TcpExt.ListenDrops": 7,
+				"IpExt.InNoRoutes": 0, "IpExt.InOctets": 18446744073709551615,
+			},
+		},
+		{
+			name: "header without values and blank lines",
+			content: "Icmp: InMsgs InErrors\n" +
+				"\n" +
+				"Udp: InDatagrams NoPorts\n" +
+				"Udp: 4 5\n",
+			want: map[string]uint64{"Udp.InDatagrams": 4, "Udp.NoPorts": 5},
+		},
+		{
+			name: "values don't match the names",
+			content: "Tcp: ActiveOpens PassiveOpens\n" +
+				"Tcp: 1 2 3\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counters := make(map[string]uint64)
+			err := readProtoCounters(writeProcFile(t, "snmp", tt.content), counters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(counters, tt.want) {
+				t.Errorf("counters = %v, want %v", counters, tt.want)
+			}
+		})
+	}
+
+	if err := readProtoCounters(filepath.Join(t.TempDir(), "missing"), map[string]uint64{}); err == nil {
+		t.Error("reading a missing file didn't fail")
+	}
+}
+
+func TestReadSockstat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    NetStackStats
+	}{
+		{
+			name: "full",
+			content: "sockets: used 18\n" +
+				"TCP: inuse 4 orphan 1 tw 7 alloc 5 mem 0\n" +
+				"UDP: inuse 2 mem 0\n" +
+				"UDPLITE: inuse 0\n" +
+				"RAW: inuse 0\n" +
+				"FRAG: inuse 0 memory 0\n",
+			want: NetStackStats{SocketsUsed: 18, TCPInUse: 4, TCPOrphan: 1, TCPTimeWait: 7, TCPAlloc: 5, UDPInUse: 2},
+		},
+		{
+			name:    "unknown and malformed values",
+			content: "sockets: used x\nTCP: inuse 3 tw\nUDP:\n",
+			want:    NetStackStats{TCPInUse: 3},
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats NetStackStats
+			if err := readSockstat(writeProcFile(t, "sockstat", tt.content), &stats); err != nil {
+				t.Fatal(err)
+			}
+			if stats != tt.want {
+				t.Errorf("stats = %+v, want %+v", stats, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetStackTrackerRead(t *testing.T) {
+	for _, file := range []string{procNetSNMP, procNetNetstat, procNetSockstat} {
+		if _, err := os.Stat(file); err != nil {
+			t.Skip("no", file)
+		}
+	}
+	tracker := NewNetStackTracker()
+	first, err := tracker.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.TCPOutSegs.Delta != 0 || first.TCPOutSegs.Rate != 0 {
+		t.Errorf("first reading has deltas: %+v", first.TCPOutSegs)
+	}
+	second, err := tracker.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.TCPOutSegs.Total < first.TCPOutSegs.Total {
+		t.Errorf("TCP segments sent went from %d to %d", first.TCPOutSegs.Total, second.TCPOutSegs.Total)
+	}
+}
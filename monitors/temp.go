@@ -4,7 +4,6 @@
 package monitors
 
 import (
-    "fmt"
     "github.com/shirou/gopsutil/v3/host"
 )
 
@@ -13,13 +12,15 @@ type TemperatureReading struct {
     Temperature  float64
 }
 
-func GetTemp() []TemperatureReading{
+// GetTemp returns every sensor reading available. Partial sensor failures
+// still return the readings that worked, an error is only returned when none did.
+func GetTemp() ([]TemperatureReading, error) {
 
  	temp, err := host.SensorsTemperatures()
-	if err != nil {
-		fmt.Println(err)
-	}
     readings := make([]TemperatureReading, 0)
+    if err != nil && len(temp) == 0 {
+        return readings, err
+    }
 
     for _, temp := range temp {
        reading := TemperatureReading{SensorKey: temp.SensorKey, Temperature: temp.Temperature}
@@ -28,7 +29,7 @@ func GetTemp() []TemperatureReading{
 
         
     }
-    return readings
+    return readings, nil
     //fmt.Printf("type of temp is %t\n", readings)
     // Example on how to get specifc value (loop over it durrr)
     //fmt.Println(readings[0].SensorKey)
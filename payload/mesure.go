@@ -0,0 +1,50 @@
+// mesure.go
+// the measurement collected every interval and sent to the update API
+
+package payload
+
+import (
+	"go_monitor/monitors"
+)
+
+// Mesure is a single collection cycle. Field names are the JSON keys the API expects.
+type Mesure struct {
+	Heartbeat        int64
+	Hostid           string
+	Hostname         string
+	Uptime           uint64
+	Os               string
+	Platform         string
+	Ip               string
+	Temp             []monitors.TemperatureReading
+	Load             map[string]float64
+	Disks            map[string]float64
+	Memory           float64
+	IOWait           *float64 `json:",omitempty"` // percent of CPU time waiting on IO since the previous cycle
+	Upload           uint64
+	Download         uint64
+	UploadInterval   uint64
+	DownloadInterval uint64
+	UploadRate       float64 // bytes/sec since the previous cycle
+	DownloadRate     float64
+	PacketsSentRate  float64 // packets/sec since the previous cycle
+	PacketsRecvRate  float64
+	Interfaces       map[string]monitors.InterfaceStats `json:",omitempty"`
+	NetStack         *monitors.NetStackStats            `json:",omitempty"`
+	Services         map[string]string
+	AgentVer         string
+	Agent            *monitors.AgentStats
+
+	// Sequence counts heartbeats from 1 and RunID changes every time the agent starts,
+	// BootID every time the host boots, so the server can tell restarts from lost samples
+	RunID    string
+	BootID   string
+	Sequence uint64
+	// Heartbeats collected this run that never reached the server
+	DroppedSamples uint64
+
+	// Seconds the server clock is ahead of the host's, once measured
+	ClockSkew *float64 `json:",omitempty"`
+	// Heartbeat in server time, only with MONKEY_CLOCK_CORRECT
+	HeartbeatCorrected int64 `json:",omitempty"`
+}
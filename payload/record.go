@@ -0,0 +1,116 @@
+// record.go
+// everything the agent produces, wrapped so it can be handed to any sink
+
+package payload
+
+import (
+	"go_monitor/events"
+	"time"
+)
+
+// Kind identifies what a record carries
+type Kind string
+
+const (
+	KindMesure      Kind = "mesure"       // a collection cycle for the update API
+	KindEvent       Kind = "event"        // open ports, processes etc. for the events API
+	KindCustomAlert Kind = "custom_alert" // a .mm alert value for the custom-events API
+	KindProcesses   Kind = "processes"    // a top processes sample, taken more often than it is sent
+)
+
+// Event is the payload the events API expects
+type Event struct {
+	Hostid    string
+	EventType string
+	EventData interface{}
+}
+
+// CustomAlert is the payload the custom-events API expects
+type CustomAlert struct {
+	HostID string      `json:"host_id"`
+	Name   string      `json:"name"`
+	Value  interface{} `json:"value"`
+}
+
+// ProcessSnapshot holds the top processes from a single collection
+type ProcessSnapshot struct {
+	CPU []events.ProcessCPUStat `json:"cpu"`
+	Mem []events.ProcessMemStat `json:"mem"`
+}
+
+// Record is a single item produced by the agent, only the field matching Kind is set
+type Record struct {
+	Kind      Kind
+	Time      time.Time
+	Mesure    *Mesure
+	Event     *Event
+	Alert     *CustomAlert
+	Processes *ProcessSnapshot
+
+	// Omitted lists mesure groups (memory, network...) a sink filter removed,
+	// so zeroed values aren't mistaken for real readings
+	Omitted map[string]bool
+}
+
+// NewMesureRecord wraps a collection cycle
+func NewMesureRecord(m *Mesure) *Record {
+	return &Record{Kind: KindMesure, Time: time.Unix(m.Heartbeat, 0), Mesure: m}
+}
+
+// NewEventRecord wraps an event
+func NewEventRecord(hostid, eventType string, data interface{}) *Record {
+	return &Record{
+		Kind:  KindEvent,
+		Time:  time.Now(),
+		Event: &Event{Hostid: hostid, EventType: eventType, EventData: data},
+	}
+}
+
+// NewCustomAlertRecord wraps a custom alert value
+func NewCustomAlertRecord(hostID, name string, value interface{}) *Record {
+	return &Record{
+		Kind:  KindCustomAlert,
+		Time:  time.Now(),
+		Alert: &CustomAlert{HostID: hostID, Name: name, Value: value},
+	}
+}
+
+// NewProcessesRecord wraps a top processes sample
+func NewProcessesRecord(cpu []events.ProcessCPUStat, mem []events.ProcessMemStat) *Record {
+	return &Record{
+		Kind:      KindProcesses,
+		Time:      time.Now(),
+		Processes: &ProcessSnapshot{CPU: cpu, Mem: mem},
+	}
+}
+
+// Name identifies the record for filtering, e.g. mesure, event.open_ports or custom_alert.Disk Usage
+func (r *Record) Name() string {
+	switch r.Kind {
+	case KindEvent:
+		return "event." + r.Event.EventType
+	case KindCustomAlert:
+		return "custom_alert." + r.Alert.Name
+	}
+	return string(r.Kind)
+}
+
+// Includes reports whether a mesure group was left in by the sink's filter
+func (r *Record) Includes(group string) bool {
+	return !r.Omitted[group]
+}
+
+// Payload returns the value a sink should serialise for this record
+func (r *Record) Payload() interface{} {
+	switch r.Kind {
+	case KindMesure:
+		return r.Mesure
+	case KindEvent:
+		return r.Event
+	case KindCustomAlert:
+		return r.Alert
+	case KindProcesses:
+		return r.Processes
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// schedule.go
+// spreads the periodic sends of a fleet of agents out over time, so a mass
+// reboot or rollout doesn't have every host hitting the server at once.
+// Offsets are derived from the host ID, a host keeps the same schedule across restarts.
+
+package schedule
+
+import (
+	"fmt"
+	"go_monitor/logger"
+	"hash/fnv"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+var log = logger.For("schedule")
+
+// Environment variables configuring splay and jitter
+const (
+	SplayEnvVar  = "MONKEY_SPLAY_SECONDS"  // most a startup send is delayed by, default 60, 0 sends at once
+	JitterEnvVar = "MONKEY_JITTER_PERCENT" // most a periodic send moves by, as a percentage of its period, default 10, up to 50
+)
+
+// Defaults for the splay and jitter
+const (
+	DefaultSplay  = 60 * time.Second
+	DefaultJitter = 10
+	maxJitter     = 50
+)
+
+// Scheduler works out when a host runs each of its periodic tasks
+type Scheduler struct {
+	hostID string
+	splay  time.Duration
+	jitter float64 // fraction of the period
+}
+
+// NewFromEnv creates a scheduler for hostID configured by MONKEY_SPLAY_SECONDS and MONKEY_JITTER_PERCENT.
+// The scheduler is always usable, settings that fail to parse keep their defaults.
+func NewFromEnv(hostID string) (*Scheduler, error) {
+	s := &Scheduler{hostID: hostID, splay: DefaultSplay, jitter: DefaultJitter / 100.0}
+	if env := os.Getenv(SplayEnvVar); env != "" {
+		seconds, err := strconv.Atoi(env)
+		if err != nil || seconds < 0 {
+			return s, fmt.Errorf("invalid %s %q", SplayEnvVar, env)
+		}
+		s.splay = time.Duration(seconds) * time.Second
+	}
+	if env := os.Getenv(JitterEnvVar); env != "" {
+		percent, err := strconv.Atoi(env)
+		if err != nil || percent < 0 || percent > maxJitter {
+			return s, fmt.Errorf("invalid %s %q, must be 0 to %d", JitterEnvVar, env, maxJitter)
+		}
+		s.jitter = float64(percent) / 100
+	}
+	return s, nil
+}
+
+// Splay is how long after startup this host first runs task, between 0 and the configured splay
+func (s *Scheduler) Splay(task string) time.Duration {
+	if s.splay <= 0 {
+		return 0
+	}
+	return time.Duration(s.hash(task, 0) % uint64(s.splay))
+}
+
+// Jitter is how far the nth run of task is moved from its place in the period,
+// at most the configured fraction of the period either way
+func (s *Scheduler) Jitter(task string, n uint64, period time.Duration) time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	// map the hash onto -1 to 1
+	unit := float64(s.hash(task, n))/math.MaxUint64*2 - 1
+	return time.Duration(unit * s.jitter * float64(period))
+}
+
+// hash mixes the host ID, task and run number into a stable pseudo random value
+func (s *Scheduler) hash(task string, n uint64) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s/%d", s.hostID, task, n)
+	// FNV barely changes its high bits when only the last byte differs, finish
+	// with the splitmix64 mixer so consecutive runs get unrelated values
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Ticker delivers a tick for each run of a task, dropping ticks nobody is ready for like time.Ticker
+type Ticker struct {
+	C    <-chan time.Time
+	stop chan struct{}
+}
+
+// NewTicker ticks for the first time after the host's splay for task, then every
+// period give or take the jitter. Runs don't drift, each is placed relative to the first.
+func (s *Scheduler) NewTicker(task string, period time.Duration) *Ticker {
+	c := make(chan time.Time, 1)
+	t := &Ticker{C: c, stop: make(chan struct{})}
+	first := time.Now().Add(s.Splay(task))
+	log.Debug("Scheduled task", "task", task, "first", first.Format(time.RFC3339), "period", period)
+
+	go func() {
+		for n := uint64(0); ; n++ {
+			next := first.Add(time.Duration(n) * period)
+			if n > 0 {
+				next = next.Add(s.Jitter(task, n, period))
+			}
+			// skip runs missed while the host was suspended
+			if time.Since(next) > period {
+				continue
+			}
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case now := <-timer.C:
+				select {
+				case c <- now:
+				default:
+				}
+			case <-t.stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// Stop stops the ticker, no more ticks are delivered
+func (t *Ticker) Stop() {
+	close(t.stop)
+}
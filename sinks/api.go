@@ -0,0 +1,191 @@
+// api.go
+// the Monitor Monkey API, the agent's primary sink
+
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go_monitor/clock"
+	"go_monitor/payload"
+	"go_monitor/status"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long to stop sending updates when the plan has too many hosts
+const TooManyHostsBackoff = 60 * time.Second
+
+// API sends records to the Monitor Monkey API
+type API struct {
+	client     *http.Client
+	baseURL    string
+	authHeader string
+	onConfig   func(body []byte)
+
+	mutex       sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewAPI creates the API sink. onConfig is called with every update response
+// body so configuration changes from the API can be applied.
+func NewAPI(client *http.Client, baseURL, authHeader string, onConfig func(body []byte)) *API {
+	return &API{
+		client:     client,
+		baseURL:    baseURL,
+		authHeader: authHeader,
+		onConfig:   onConfig,
+	}
+}
+
+// Name identifies the sink
+func (a *API) Name() string {
+	return "api"
+}
+
+// IsUplink marks the API as carrying heartbeats to the server
+func (a *API) IsUplink() bool {
+	return true
+}
+
+// UpdateURL is where collection cycles are sent
+func (a *API) UpdateURL() string {
+	return a.baseURL + "/api/update/"
+}
+
+// Configure registers the host with the API and returns the configuration response
+func (a *API) Configure(hostDetails interface{}) ([]byte, error) {
+	resp, err := a.post(a.baseURL+"/api/configure/", hostDetails)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Send delivers a record to the matching API endpoint, other kinds are ignored
+func (a *API) Send(rec *payload.Record) error {
+	switch rec.Kind {
+	case payload.KindMesure:
+		return a.sendUpdate(rec.Mesure)
+	case payload.KindEvent:
+		if err := a.sendAndCheck(a.baseURL+"/api/events/", rec.Event); err != nil {
+			return err
+		}
+		log.Info("Sent event", "type", rec.Event.EventType)
+	case payload.KindCustomAlert:
+		if err := a.sendAndCheck(a.baseURL+"/api/custom-events/", rec.Alert); err != nil {
+			return err
+		}
+		log.Info("Sent custom alert", "alert", rec.Alert.Name)
+	}
+	return nil
+}
+
+// sendUpdate posts a collection cycle and hands the response to onConfig
+func (a *API) sendUpdate(m *payload.Mesure) error {
+	a.mutex.Lock()
+	paused := time.Now().Before(a.pausedUntil)
+	a.mutex.Unlock()
+	if paused {
+		status.RecordDroppedSample()
+		return nil
+	}
+
+	start := time.Now()
+	resp, err := a.post(a.UpdateURL(), m)
+	received := time.Now()
+	status.RecordSendLatency(received.Sub(start))
+	if err != nil {
+		status.RecordSend(err)
+		return err
+	}
+
+	// Always close the body to prevent resource leaks
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		err = fmt.Errorf("failed to read update response: %w", err)
+		status.RecordSend(err)
+		return err
+	}
+
+	var responseMap map[string]interface{}
+	if err := json.Unmarshal(body, &responseMap); err != nil {
+		err = fmt.Errorf("failed to parse update response (status %d): %w", resp.StatusCode, err)
+		status.RecordSend(err)
+		return err
+	}
+
+	// A server timestamp is more precise than the Date header
+	if serverTime, ok := responseMap["server_time"].(float64); ok {
+		sec, frac := math.Modf(serverTime)
+		clock.ObserveServerTime(time.Unix(int64(sec), int64(frac*1e9)), start, received)
+	}
+
+	// Check for "tomany" message
+	if value, ok := responseMap["message"]; ok && value == "tomany" {
+		status.RecordSend(nil)
+		status.RecordDroppedSample()
+		log.Warn("You have too many hosts being monitored for your payment plan, please remove some hosts or purchase some more :)")
+		log.Warn("I'll now go to sleep for a while 😪😪")
+		a.mutex.Lock()
+		a.pausedUntil = time.Now().Add(TooManyHostsBackoff)
+		a.mutex.Unlock()
+		return nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = fmt.Errorf("update rejected with status %d", resp.StatusCode)
+		status.RecordSend(err)
+		return err
+	}
+	status.RecordSend(nil)
+
+	if a.onConfig != nil {
+		a.onConfig(body)
+	}
+	return nil
+}
+
+// sendAndCheck posts a payload and fails on a non 2xx response
+func (a *API) sendAndCheck(url string, data interface{}) error {
+	resp, err := a.post(url, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("rejected with status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// post sends data as JSON with the agent's credentials
+func (a *API) post(url string, data interface{}) (*http.Response, error) {
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", a.authHeader)
+
+	sent := time.Now()
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	clock.ObserveResponse(resp, sent, time.Now())
+	return resp, nil
+}
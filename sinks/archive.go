@@ -0,0 +1,280 @@
+// archive.go
+// keeps a local JSON lines copy of everything sent, rotated by size and age
+// with old files gzipped, for audits and for when the API is unreachable
+
+package sinks
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"go_monitor/payload"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables configuring the archive
+const (
+	ArchiveDirEnvVar         = "MONKEY_ARCHIVE_DIR"          // enables the archive, e.g. /var/lib/monitor-monkey/archive
+	ArchiveMaxSizeEnvVar     = "MONKEY_ARCHIVE_MAX_SIZE_MB"  // rotate when the file reaches this size, default 50
+	ArchiveRotateHoursEnvVar = "MONKEY_ARCHIVE_ROTATE_HOURS" // rotate when the file is this old, default 24, 0 only rotates on size
+	ArchiveKeepEnvVar        = "MONKEY_ARCHIVE_KEEP"         // rotated files to keep, default 30, 0 keeps all
+)
+
+// Current file name, rotated files are archive-<time>.jsonl.gz
+const (
+	archiveCurrent = "archive.jsonl"
+	archivePrefix  = "archive-"
+	archiveSuffix  = ".jsonl"
+	archiveGzip    = ".gz"
+)
+
+// rotateRetryDelay is how long the archive waits after a failed rotation before
+// trying again, in the meantime records are appended to the current file
+const rotateRetryDelay = 10 * time.Minute
+
+// ArchiveOptions configures the archive sink
+type ArchiveOptions struct {
+	Dir            string
+	MaxSize        int64
+	RotateInterval time.Duration
+	Keep           int
+}
+
+// Archive writes one JSON line per record
+type Archive struct {
+	opts        ArchiveOptions
+	file        *os.File
+	size        int64
+	opened      time.Time // the time of the file's first record
+	rotateAfter time.Time // set after a failed rotation
+}
+
+// ArchiveOptionsFromEnv reads the MONKEY_ARCHIVE_* variables, ok is false if no directory is set
+func ArchiveOptionsFromEnv() (opts ArchiveOptions, ok bool, err error) {
+	opts = ArchiveOptions{
+		Dir:            os.Getenv(ArchiveDirEnvVar),
+		MaxSize:        50 << 20,
+		RotateInterval: 24 * time.Hour,
+		Keep:           30,
+	}
+	if opts.Dir == "" {
+		return opts, false, nil
+	}
+
+	if env := os.Getenv(ArchiveMaxSizeEnvVar); env != "" {
+		mb, err := strconv.Atoi(env)
+		if err != nil || mb < 1 {
+			return opts, true, fmt.Errorf("invalid %s %q", ArchiveMaxSizeEnvVar, env)
+		}
+		opts.MaxSize = int64(mb) << 20
+	}
+	if env := os.Getenv(ArchiveRotateHoursEnvVar); env != "" {
+		hours, err := strconv.Atoi(env)
+		if err != nil || hours < 0 {
+			return opts, true, fmt.Errorf("invalid %s %q", ArchiveRotateHoursEnvVar, env)
+		}
+		opts.RotateInterval = time.Duration(hours) * time.Hour
+	}
+	if env := os.Getenv(ArchiveKeepEnvVar); env != "" {
+		keep, err := strconv.Atoi(env)
+		if err != nil || keep < 0 {
+			return opts, true, fmt.Errorf("invalid %s %q", ArchiveKeepEnvVar, env)
+		}
+		opts.Keep = keep
+	}
+	return opts, true, nil
+}
+
+// NewArchive opens the archive, appending to the current file if there is one,
+// and compresses any rotated files left uncompressed by an earlier run
+func NewArchive(opts ArchiveOptions) (*Archive, error) {
+	if err := os.MkdirAll(opts.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	a := &Archive{opts: opts}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	a.compressLeftovers()
+	return a, nil
+}
+
+// Name identifies the sink
+func (a *Archive) Name() string {
+	return "archive"
+}
+
+// Send appends the record, rotating first if the file is due.
+// Process samples aren't sent anywhere on their own so they aren't archived.
+func (a *Archive) Send(rec *payload.Record) error {
+	if rec.Kind == payload.KindProcesses {
+		return nil
+	}
+
+	jsonBytes, err := json.Marshal(newEnvelope(rec))
+	if err != nil {
+		return fmt.Errorf("failed to marshal archive line: %w", err)
+	}
+	jsonBytes = append(jsonBytes, '\n')
+
+	if a.file == nil {
+		// an earlier rotation couldn't reopen the file
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+	if a.dueForRotation(int64(len(jsonBytes))) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(jsonBytes)
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// Close flushes and closes the current file
+func (a *Archive) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+func (a *Archive) dueForRotation(next int64) bool {
+	if a.size == 0 || time.Now().Before(a.rotateAfter) {
+		return false
+	}
+	if a.size+next > a.opts.MaxSize {
+		return true
+	}
+	return a.opts.RotateInterval > 0 && time.Since(a.opened) >= a.opts.RotateInterval
+}
+
+// open opens the current file for appending. A file kept from an earlier run is
+// as old as its first record, so restarts don't put off the rotation.
+func (a *Archive) open() error {
+	file, err := os.OpenFile(filepath.Join(a.opts.Dir, archiveCurrent), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat archive: %w", err)
+	}
+	a.file = file
+	a.size = info.Size()
+	a.opened = time.Now()
+	if a.size > 0 {
+		a.opened = firstRecordTime(file.Name(), info.ModTime())
+	}
+	return nil
+}
+
+// firstRecordTime returns the time of the first record in file, or fallback if
+// it can't be read
+func firstRecordTime(file string, fallback time.Time) time.Time {
+	f, err := os.Open(file)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+
+	var first struct {
+		Time time.Time `json:"time"`
+	}
+	if err := json.NewDecoder(f).Decode(&first); err != nil || first.Time.IsZero() {
+		return fallback
+	}
+	return first.Time
+}
+
+// rotate renames the current file with a timestamp, compresses it and starts a new one.
+// If the rename fails the current file is reopened and the rotation retried later.
+func (a *Archive) rotate() error {
+	if err := a.Close(); err != nil {
+		log.Error("Failed to close archive before rotating", "err", err)
+	}
+
+	rotated := filepath.Join(a.opts.Dir, archivePrefix+time.Now().UTC().Format("20060102T150405")+archiveSuffix)
+	if err := os.Rename(filepath.Join(a.opts.Dir, archiveCurrent), rotated); err != nil {
+		log.Error("Failed to rotate archive", "err", err, "retry_in", rotateRetryDelay)
+		a.rotateAfter = time.Now().Add(rotateRetryDelay)
+	} else if err := compressFile(rotated); err != nil {
+		log.Error("Failed to compress rotated archive", "file", rotated, "err", err)
+	}
+	a.prune()
+
+	return a.open()
+}
+
+// compressLeftovers gzips rotated files an interrupted rotation didn't get to
+func (a *Archive) compressLeftovers() {
+	leftovers, _ := filepath.Glob(filepath.Join(a.opts.Dir, archivePrefix+"*"+archiveSuffix))
+	for _, file := range leftovers {
+		if err := compressFile(file); err != nil {
+			log.Error("Failed to compress rotated archive", "file", file, "err", err)
+		}
+	}
+}
+
+// prune removes the oldest rotated files beyond the number to keep
+func (a *Archive) prune() {
+	if a.opts.Keep == 0 {
+		return
+	}
+	rotated, _ := filepath.Glob(filepath.Join(a.opts.Dir, archivePrefix+"*"+archiveSuffix+"*"))
+	if len(rotated) <= a.opts.Keep {
+		return
+	}
+	// names sort by their timestamp
+	sort.Strings(rotated)
+	for _, file := range rotated[:len(rotated)-a.opts.Keep] {
+		if err := os.Remove(file); err != nil {
+			log.Error("Failed to remove old archive", "file", file, "err", err)
+		}
+	}
+}
+
+// compressFile replaces file with file.gz
+func compressFile(file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gzName := file + archiveGzip
+	out, err := os.OpenFile(gzName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	gz.Name = strings.TrimSuffix(filepath.Base(gzName), archiveGzip)
+	_, err = io.Copy(gz, in)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(gzName)
+		return err
+	}
+	return os.Remove(file)
+}
@@ -0,0 +1,210 @@
+// dispatcher.go
+// fans each record out to every sink, each with its own queue and goroutine
+// so a slow or failing destination can't hold up collection or the other sinks
+
+package sinks
+
+import (
+	"fmt"
+	"go_monitor/payload"
+	"go_monitor/status"
+	"sync"
+	"time"
+)
+
+// Dispatcher delivers published records to all registered sinks
+type Dispatcher struct {
+	outputs []*output
+	mutex   sync.RWMutex
+	stopped bool
+	paused  bool
+	wg      sync.WaitGroup
+}
+
+// output is a sink with its filter, queue and delivery statistics
+type output struct {
+	sink   Sink
+	filter Filter
+	queue  chan *payload.Record
+	uplink bool
+
+	mutex sync.Mutex
+	stats status.SinkStatus
+}
+
+// NewDispatcher creates a dispatcher with no sinks
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// Add registers a sink, must be called before Start
+func (d *Dispatcher) Add(sink Sink, opts Options) {
+	if opts.BufferSize < 1 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	uplink, ok := sink.(Uplink)
+	d.outputs = append(d.outputs, &output{
+		sink:   sink,
+		filter: opts.Filter,
+		queue:  make(chan *payload.Record, opts.BufferSize),
+		uplink: ok && uplink.IsUplink(),
+		stats:  status.SinkStatus{Name: sink.Name()},
+	})
+	log.Info("Added output sink", "sink", sink.Name(), "buffer", opts.BufferSize,
+		"include", opts.Filter.Include, "exclude", opts.Filter.Exclude)
+}
+
+// Start begins delivering records to every sink
+func (d *Dispatcher) Start() {
+	status.SetUplink(d.hasUplink())
+	for _, o := range d.outputs {
+		d.wg.Add(1)
+		go func(o *output) {
+			defer d.wg.Done()
+			for rec := range o.queue {
+				o.deliver(rec)
+			}
+		}(o)
+	}
+}
+
+// Publish queues a record for every sink whose filter accepts it.
+// It never blocks, a sink that has fallen behind loses its oldest record instead.
+// Records published while paused are discarded.
+func (d *Dispatcher) Publish(rec *payload.Record) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.stopped {
+		return
+	}
+	if d.paused {
+		if rec.Kind == payload.KindMesure && d.hasUplink() {
+			status.RecordDroppedSample()
+		}
+		return
+	}
+
+	for _, o := range d.outputs {
+		filtered, ok := o.filter.Apply(rec)
+		if !ok {
+			continue
+		}
+		o.enqueue(filtered)
+	}
+}
+
+// SetPaused stops or restarts sending, collection carries on regardless
+func (d *Dispatcher) SetPaused(paused bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.paused = paused
+}
+
+// hasUplink reports whether any sink carries heartbeats to the server
+func (d *Dispatcher) hasUplink() bool {
+	for _, o := range d.outputs {
+		if o.uplink {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop stops accepting records and waits up to timeout for queued ones to be delivered
+func (d *Dispatcher) Stop(timeout time.Duration) {
+	d.mutex.Lock()
+	if d.stopped {
+		d.mutex.Unlock()
+		return
+	}
+	d.stopped = true
+	for _, o := range d.outputs {
+		close(o.queue)
+	}
+	d.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("Timed out delivering queued records", "timeout", timeout)
+	}
+
+	for _, o := range d.outputs {
+		if closer, ok := o.sink.(Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Error("Failed to close sink", "sink", o.sink.Name(), "err", err)
+			}
+		}
+	}
+}
+
+// Status reports queue depth and delivery counts for every sink
+func (d *Dispatcher) Status() []status.SinkStatus {
+	statuses := make([]status.SinkStatus, 0, len(d.outputs))
+	for _, o := range d.outputs {
+		o.mutex.Lock()
+		s := o.stats
+		o.mutex.Unlock()
+		s.QueueDepth = len(o.queue)
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// enqueue adds a record to the queue, discarding the oldest one if it is full
+func (o *output) enqueue(rec *payload.Record) {
+	for {
+		select {
+		case o.queue <- rec:
+			return
+		default:
+		}
+
+		select {
+		case dropped := <-o.queue:
+			o.countDroppedSample(dropped)
+			o.mutex.Lock()
+			o.stats.Dropped++
+			o.mutex.Unlock()
+			log.With("sink", o.sink.Name()).Warn("Sink queue full, dropping oldest record")
+		default:
+		}
+	}
+}
+
+// deliver sends one record, a panicking sink only loses that record
+func (o *output) deliver(rec *payload.Record) {
+	defer func() {
+		if r := recover(); r != nil {
+			o.recordResult(rec, fmt.Errorf("sink panicked: %v", r))
+		}
+	}()
+	o.recordResult(rec, o.sink.Send(rec))
+}
+
+func (o *output) recordResult(rec *payload.Record, err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if err != nil {
+		o.countDroppedSample(rec)
+		o.stats.Failed++
+		o.stats.LastError = err.Error()
+		o.stats.LastErrorTime = time.Now()
+		log.With("sink", o.sink.Name(), "record", rec.Name()).Error("Sink failed to send record", "err", err)
+		return
+	}
+	o.stats.Sent++
+}
+
+// countDroppedSample counts a heartbeat an uplink lost on its way to the server
+func (o *output) countDroppedSample(rec *payload.Record) {
+	if o.uplink && rec.Kind == payload.KindMesure {
+		status.RecordDroppedSample()
+	}
+}
@@ -0,0 +1,104 @@
+// filter.go
+// per sink include/exclude rules on which records and metrics are forwarded
+
+package sinks
+
+import (
+	"fmt"
+	"go_monitor/payload"
+	"path"
+	"strings"
+)
+
+// Filter selects records by name using glob patterns (see payload.Record.Name).
+// Metric groups within a mesure can be selected as mesure.<group>, e.g. mesure.load.
+// A pattern matching a parent name also matches its children, so "mesure" covers mesure.load.
+type Filter struct {
+	Include []string // empty means everything
+	Exclude []string
+}
+
+// mesureField is a group of mesure fields that can be filtered out together
+type mesureField struct {
+	name  string
+	clear func(m *payload.Mesure)
+}
+
+// mesureFields lists the metric groups of a mesure that filters can select
+var mesureFields = []mesureField{
+	{"temp", func(m *payload.Mesure) { m.Temp = nil }},
+	{"load", func(m *payload.Mesure) { m.Load, m.IOWait = nil, nil }},
+	{"disks", func(m *payload.Mesure) { m.Disks = nil }},
+	{"memory", func(m *payload.Mesure) { m.Memory = 0 }},
+	{"network", func(m *payload.Mesure) {
+		m.Upload, m.Download, m.UploadInterval, m.DownloadInterval = 0, 0, 0, 0
+		m.UploadRate, m.DownloadRate, m.PacketsSentRate, m.PacketsRecvRate = 0, 0, 0, 0
+	}},
+	{"interfaces", func(m *payload.Mesure) { m.Interfaces = nil }},
+	{"netstack", func(m *payload.Mesure) { m.NetStack = nil }},
+	{"services", func(m *payload.Mesure) { m.Services = nil }},
+	{"agent", func(m *payload.Mesure) { m.Agent = nil }},
+}
+
+// NewFilter validates the patterns and returns a filter
+func NewFilter(include, exclude []string) (Filter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Filter{}, fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+	}
+	return Filter{Include: include, Exclude: exclude}, nil
+}
+
+// Apply returns the record as the sink should see it and whether it should be sent at all.
+// Mesures with some metric groups filtered out are copied, the original is never modified.
+func (f Filter) Apply(rec *payload.Record) (*payload.Record, bool) {
+	if rec.Kind != payload.KindMesure {
+		return rec, f.allows(rec.Name())
+	}
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return rec, true
+	}
+
+	m := *rec.Mesure
+	omitted := make(map[string]bool)
+	for _, field := range mesureFields {
+		if !f.allows("mesure." + field.name) {
+			field.clear(&m)
+			omitted[field.name] = true
+		}
+	}
+	if len(omitted) == len(mesureFields) {
+		return nil, false
+	}
+	if len(omitted) == 0 {
+		return rec, true
+	}
+	copied := *rec
+	copied.Mesure = &m
+	copied.Omitted = omitted
+	return &copied, true
+}
+
+// allows checks a name, and its parent name, against the filter patterns
+func (f Filter) allows(name string) bool {
+	names := []string{name}
+	if i := strings.Index(name, "."); i > 0 {
+		names = append(names, name[:i])
+	}
+	if matchAny(f.Exclude, names) {
+		return false
+	}
+	return len(f.Include) == 0 || matchAny(f.Include, names)
+}
+
+func matchAny(patterns []string, names []string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
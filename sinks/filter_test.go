@@ -0,0 +1,182 @@
+package sinks
+
+import (
+	"go_monitor/monitors"
+	"go_monitor/payload"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testMesure returns a mesure with every metric group filled in
+func testMesure() *payload.Mesure {
+	iowait := 3.5
+	return &payload.Mesure{
+		Heartbeat:  1700000000,
+		Hostid:     "abc123",
+		Uptime:     3600,
+		Temp:       []monitors.TemperatureReading{{SensorKey: "cpu", Temperature: 50}},
+		Load:       map[string]float64{"load1": 0.5},
+		IOWait:     &iowait,
+		Disks:      map[string]float64{"/": 20},
+		Memory:     40,
+		Upload:     1000,
+		Download:   2000,
+		UploadRate: 10,
+		Interfaces: map[string]monitors.InterfaceStats{"eth0": {Kind: monitors.IfacePhysical}},
+		NetStack:   &monitors.NetStackStats{SocketsUsed: 18},
+		Services:   map[string]string{"nginx": "active"},
+		Agent:      &monitors.AgentStats{},
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	tests := []struct {
+		name        string
+		include     []string
+		exclude     []string
+		rec         *payload.Record
+		wantSent    bool
+		wantOmitted []string // for mesures, the groups filtered out
+	}{
+		{
+			name:     "no filter",
+			rec:      payload.NewMesureRecord(testMesure()),
+			wantSent: true,
+		},
+		{
+			name:     "event included by default",
+			exclude:  []string{"custom_alert"},
+			rec:      payload.NewEventRecord("abc123", "open_ports", nil),
+			wantSent: true,
+		},
+		{
+			name:    "event excluded by its parent name",
+			exclude: []string{"event"},
+			rec:     payload.NewEventRecord("abc123", "open_ports", nil),
+		},
+		{
+			name:     "event included by glob",
+			include:  []string{"event.port_*"},
+			rec:      payload.NewEventRecord("abc123", "port_opened", nil),
+			wantSent: true,
+		},
+		{
+			name:    "event not in the include list",
+			include: []string{"event.port_*"},
+			rec:     payload.NewEventRecord("abc123", "open_ports", nil),
+		},
+		{
+			name:    "exclude wins over include",
+			include: []string{"event"},
+			exclude: []string{"event.processes*"},
+			rec:     payload.NewEventRecord("abc123", "processes_summary", nil),
+		},
+		{
+			name:     "custom alert with a space in its name",
+			include:  []string{"custom_alert.Disk Usage"},
+			rec:      payload.NewCustomAlertRecord("abc123", "Disk Usage", 90),
+			wantSent: true,
+		},
+		{
+			name:     "whole mesure included",
+			include:  []string{"mesure"},
+			rec:      payload.NewMesureRecord(testMesure()),
+			wantSent: true,
+		},
+		{
+			name:        "mesure groups included",
+			include:     []string{"mesure.load", "mesure.memory"},
+			rec:         payload.NewMesureRecord(testMesure()),
+			wantSent:    true,
+			wantOmitted: []string{"agent", "disks", "interfaces", "netstack", "network", "services", "temp"},
+		},
+		{
+			name:        "mesure groups excluded",
+			exclude:     []string{"mesure.network", "mesure.inter*"},
+			rec:         payload.NewMesureRecord(testMesure()),
+			wantSent:    true,
+			wantOmitted: []string{"interfaces", "network"},
+		},
+		{
+			name:    "every mesure group excluded",
+			exclude: []string{"mesure.*"},
+			rec:     payload.NewMesureRecord(testMesure()),
+		},
+		{
+			name:    "mesure not in the include list",
+			include: []string{"event"},
+			rec:     payload.NewMesureRecord(testMesure()),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var original payload.Mesure
+			if tt.rec.Mesure != nil {
+				original = *tt.rec.Mesure
+			}
+
+			got, sent := f.Apply(tt.rec)
+			if sent != tt.wantSent {
+				t.Fatalf("sent = %v, want %v", sent, tt.wantSent)
+			}
+			if tt.rec.Mesure != nil && !reflect.DeepEqual(*tt.rec.Mesure, original) {
+				t.Error("Apply modified the original mesure")
+			}
+			if !sent {
+				return
+			}
+
+			var omitted []string
+			for group := range got.Omitted {
+				omitted = append(omitted, group)
+			}
+			sort.Strings(omitted)
+			if !reflect.DeepEqual(omitted, tt.wantOmitted) {
+				t.Errorf("omitted = %q, want %q", omitted, tt.wantOmitted)
+			}
+			if len(tt.wantOmitted) == 0 && got != tt.rec {
+				t.Error("a record with nothing filtered out was copied")
+			}
+			for _, group := range tt.wantOmitted {
+				if got.Includes(group) {
+					t.Errorf("Includes(%q) after it was filtered out", group)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterApplyClearsGroups(t *testing.T) {
+	f, err := NewFilter(nil, []string{"mesure.load", "mesure.network", "mesure.netstack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, sent := f.Apply(payload.NewMesureRecord(testMesure()))
+	if !sent {
+		t.Fatal("mesure not sent")
+	}
+	m := got.Mesure
+	if m.Load != nil || m.IOWait != nil || m.Upload != 0 || m.Download != 0 || m.UploadRate != 0 || m.NetStack != nil {
+		t.Errorf("excluded groups left in: %+v", m)
+	}
+	if m.Memory != 40 || m.Disks["/"] != 20 || m.Uptime != 3600 || m.Hostid != "abc123" {
+		t.Errorf("included values lost: %+v", m)
+	}
+	if !got.Includes("memory") || got.Includes("load") {
+		t.Errorf("Includes doesn't match the filter: %v", got.Omitted)
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	if _, err := NewFilter([]string{"event.*"}, []string{"mesure.temp"}); err != nil {
+		t.Errorf("valid patterns rejected: %v", err)
+	}
+	if _, err := NewFilter(nil, []string{"event.[port"}); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
@@ -0,0 +1,272 @@
+// mqtt.go
+// publishes heartbeats, events and custom alerts to an MQTT broker, for sites
+// where a broker is the only uplink
+
+package sinks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_monitor/payload"
+	"go_monitor/status"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Environment variables configuring the MQTT sink
+const (
+	MQTTBrokerEnvVar      = "MONKEY_MQTT_BROKER"       // e.g. tcp://localhost:1883, ssl://broker:8883 or ws://broker:80/mqtt
+	MQTTClientIDEnvVar    = "MONKEY_MQTT_CLIENT_ID"    // default monitor-monkey-<hostid>
+	MQTTUsernameEnvVar    = "MONKEY_MQTT_USERNAME"     // optional
+	MQTTPasswordEnvVar    = "MONKEY_MQTT_PASSWORD"     // optional
+	MQTTTopicPrefixEnvVar = "MONKEY_MQTT_TOPIC_PREFIX" // default monitor-monkey/{hostid}, {hostname} is also replaced
+	MQTTQoSEnvVar         = "MONKEY_MQTT_QOS"          // 0, 1 (default) or 2
+	MQTTCAFileEnvVar      = "MONKEY_MQTT_CA_FILE"      // PEM CA bundle to verify the broker with
+	MQTTCertFileEnvVar    = "MONKEY_MQTT_CERT_FILE"    // PEM client certificate, with MONKEY_MQTT_KEY_FILE
+	MQTTKeyFileEnvVar     = "MONKEY_MQTT_KEY_FILE"     // PEM client key
+	MQTTInsecureEnvVar    = "MONKEY_MQTT_INSECURE"     // true skips broker certificate verification
+)
+
+// DefaultMQTTTopicPrefix is where topics go when MONKEY_MQTT_TOPIC_PREFIX isn't set
+const DefaultMQTTTopicPrefix = "monitor-monkey/{hostid}"
+
+// Payloads of the retained <prefix>/status topic, offline is the broker's Last Will
+const (
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+)
+
+// How long to wait for the broker to acknowledge a publish
+const mqttPublishTimeout = 10 * time.Second
+
+// MQTTOptions configures the MQTT sink
+type MQTTOptions struct {
+	Broker      string
+	ClientID    string
+	Username    string
+	Password    string
+	TopicPrefix string
+	QoS         byte
+	TLS         *tls.Config // nil uses the defaults for ssl://, tls:// and wss:// brokers
+
+	// Uplink makes heartbeat results count as the agent's send status,
+	// for when the broker replaces the Monitor Monkey API
+	Uplink bool
+}
+
+// MQTT publishes records as JSON, one topic per kind of record:
+//
+//	<prefix>/heartbeat
+//	<prefix>/events/<type>
+//	<prefix>/custom_alerts/<name>
+//	<prefix>/processes
+//	<prefix>/status   retained online/offline
+type MQTT struct {
+	client mqtt.Client
+	prefix string
+	qos    byte
+	uplink bool
+}
+
+// MQTTOptionsFromEnv reads the MONKEY_MQTT_* variables, ok is false if no broker is set
+func MQTTOptionsFromEnv(hostid, hostname string) (opts MQTTOptions, ok bool, err error) {
+	opts.Broker = os.Getenv(MQTTBrokerEnvVar)
+	if opts.Broker == "" {
+		return opts, false, nil
+	}
+
+	opts.ClientID = os.Getenv(MQTTClientIDEnvVar)
+	if opts.ClientID == "" {
+		opts.ClientID = "monitor-monkey-" + hostid
+	}
+	opts.Username = os.Getenv(MQTTUsernameEnvVar)
+	opts.Password = os.Getenv(MQTTPasswordEnvVar)
+
+	prefix := os.Getenv(MQTTTopicPrefixEnvVar)
+	if prefix == "" {
+		prefix = DefaultMQTTTopicPrefix
+	}
+	prefix = strings.ReplaceAll(prefix, "{hostid}", topicSegment(hostid))
+	prefix = strings.ReplaceAll(prefix, "{hostname}", topicSegment(hostname))
+	opts.TopicPrefix = strings.TrimRight(prefix, "/")
+	if opts.TopicPrefix == "" || strings.ContainsAny(opts.TopicPrefix, "+#") {
+		return opts, true, fmt.Errorf("invalid %s %q", MQTTTopicPrefixEnvVar, prefix)
+	}
+
+	opts.QoS = 1
+	if env := os.Getenv(MQTTQoSEnvVar); env != "" {
+		qos, err := strconv.Atoi(env)
+		if err != nil || qos < 0 || qos > 2 {
+			return opts, true, fmt.Errorf("invalid %s %q (want 0, 1 or 2)", MQTTQoSEnvVar, env)
+		}
+		opts.QoS = byte(qos)
+	}
+
+	opts.TLS, err = mqttTLSFromEnv()
+	return opts, true, err
+}
+
+// mqttTLSFromEnv builds a TLS config when a CA, client certificate or insecure mode is set
+func mqttTLSFromEnv() (*tls.Config, error) {
+	caFile := os.Getenv(MQTTCAFileEnvVar)
+	certFile := os.Getenv(MQTTCertFileEnvVar)
+	keyFile := os.Getenv(MQTTKeyFileEnvVar)
+	insecure := false
+	if env := os.Getenv(MQTTInsecureEnvVar); env != "" {
+		var err error
+		if insecure, err = strconv.ParseBool(env); err != nil {
+			return nil, fmt.Errorf("invalid %s %q", MQTTInsecureEnvVar, env)
+		}
+	}
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewMQTT starts connecting to the broker in the background and returns the sink.
+// Records sent while the broker is unreachable fail and are counted against the sink.
+func NewMQTT(opts MQTTOptions) *MQTT {
+	m := &MQTT{
+		prefix: opts.TopicPrefix,
+		qos:    opts.QoS,
+		uplink: opts.Uplink,
+	}
+
+	clientOpts := mqtt.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetCleanSession(true).
+		SetKeepAlive(30*time.Second).
+		SetConnectTimeout(10*time.Second).
+		SetWriteTimeout(mqttPublishTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		SetMaxReconnectInterval(2*time.Minute).
+		SetWill(m.statusTopic(), mqttOffline, opts.QoS, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Info("Connected to MQTT broker", "broker", opts.Broker)
+			// retained so subscribers see the current state straight away, republished on every reconnect
+			token := c.Publish(m.statusTopic(), opts.QoS, true, mqttOnline)
+			go func() {
+				if token.WaitTimeout(mqttPublishTimeout) && token.Error() != nil {
+					log.Error("Failed to publish MQTT online status", "err", token.Error())
+				}
+			}()
+		}).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			log.Warn("Lost connection to MQTT broker", "broker", opts.Broker, "err", err)
+		})
+	if opts.TLS != nil {
+		clientOpts.SetTLSConfig(opts.TLS)
+	}
+
+	m.client = mqtt.NewClient(clientOpts)
+	m.client.Connect()
+	return m
+}
+
+// Name identifies the sink
+func (m *MQTT) Name() string {
+	return "mqtt"
+}
+
+// IsUplink reports whether heartbeats sent over MQTT count as reaching the server
+func (m *MQTT) IsUplink() bool {
+	return m.uplink
+}
+
+// Send publishes the record as JSON on its topic
+func (m *MQTT) Send(rec *payload.Record) error {
+	start := time.Now()
+	err := m.publish(rec)
+	if m.uplink && rec.Kind == payload.KindMesure {
+		status.RecordSendLatency(time.Since(start))
+		status.RecordSend(err)
+	}
+	return err
+}
+
+func (m *MQTT) publish(rec *payload.Record) error {
+	if !m.client.IsConnectionOpen() {
+		return errors.New("not connected to MQTT broker")
+	}
+
+	jsonBytes, err := json.Marshal(rec.Payload())
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	token := m.client.Publish(m.topic(rec), m.qos, false, jsonBytes)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return errors.New("timed out publishing to MQTT broker")
+	}
+	return token.Error()
+}
+
+// Close marks the agent offline and disconnects. The Last Will only covers
+// connections that drop, a clean disconnect has to say so itself.
+func (m *MQTT) Close() error {
+	if m.client.IsConnectionOpen() {
+		m.client.Publish(m.statusTopic(), m.qos, true, mqttOffline).WaitTimeout(mqttPublishTimeout)
+	}
+	m.client.Disconnect(250)
+	return nil
+}
+
+// topic is where a record is published
+func (m *MQTT) topic(rec *payload.Record) string {
+	switch rec.Kind {
+	case payload.KindMesure:
+		return m.prefix + "/heartbeat"
+	case payload.KindEvent:
+		return m.prefix + "/events/" + topicSegment(rec.Event.EventType)
+	case payload.KindCustomAlert:
+		return m.prefix + "/custom_alerts/" + topicSegment(rec.Alert.Name)
+	}
+	return m.prefix + "/" + string(rec.Kind)
+}
+
+func (m *MQTT) statusTopic() string {
+	return m.prefix + "/status"
+}
+
+// topicSegment keeps a name to a single topic level without wildcards, e.g. "Disk Usage" is Disk_Usage
+func topicSegment(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
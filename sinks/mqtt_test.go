@@ -0,0 +1,368 @@
+package sinks
+
+import (
+	"fmt"
+	"go_monitor/payload"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Environment variable naming a broker for TestMQTTBroker, e.g. tcp://localhost:1883
+const mqttTestBrokerEnvVar = "MONKEY_MQTT_TEST_BROKER"
+
+func TestMQTTOptionsFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantOK     bool
+		wantErr    bool
+		wantID     string
+		wantPrefix string
+		wantQoS    byte
+		wantTLS    bool
+	}{
+		{
+			name: "no broker",
+			env:  map[string]string{},
+		},
+		{
+			name:       "defaults",
+			env:        map[string]string{MQTTBrokerEnvVar: "tcp://localhost:1883"},
+			wantOK:     true,
+			wantID:     "monitor-monkey-abc123",
+			wantPrefix: "monitor-monkey/abc123",
+			wantQoS:    1,
+		},
+		{
+			name: "client id, hostname in prefix and QoS 2",
+			env: map[string]string{
+				MQTTBrokerEnvVar:      "tcp://localhost:1883",
+				MQTTClientIDEnvVar:    "agent-1",
+				MQTTTopicPrefixEnvVar: "site/{hostname}/agent/",
+				MQTTQoSEnvVar:         "2",
+			},
+			wantOK:     true,
+			wantID:     "agent-1",
+			wantPrefix: "site/web_01/agent",
+			wantQoS:    2,
+		},
+		{
+			name: "wildcard in prefix",
+			env: map[string]string{
+				MQTTBrokerEnvVar:      "tcp://localhost:1883",
+				MQTTTopicPrefixEnvVar: "site/+/agent",
+			},
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name: "empty prefix",
+			env: map[string]string{
+				MQTTBrokerEnvVar:      "tcp://localhost:1883",
+				MQTTTopicPrefixEnvVar: "/",
+			},
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name: "QoS out of range",
+			env: map[string]string{
+				MQTTBrokerEnvVar: "tcp://localhost:1883",
+				MQTTQoSEnvVar:    "3",
+			},
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name: "insecure",
+			env: map[string]string{
+				MQTTBrokerEnvVar:   "ssl://broker:8883",
+				MQTTInsecureEnvVar: "true",
+			},
+			wantOK:     true,
+			wantID:     "monitor-monkey-abc123",
+			wantPrefix: "monitor-monkey/abc123",
+			wantQoS:    1,
+			wantTLS:    true,
+		},
+		{
+			name: "invalid insecure",
+			env: map[string]string{
+				MQTTBrokerEnvVar:   "ssl://broker:8883",
+				MQTTInsecureEnvVar: "maybe",
+			},
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name: "missing CA file",
+			env: map[string]string{
+				MQTTBrokerEnvVar: "ssl://broker:8883",
+				MQTTCAFileEnvVar: "/nonexistent/ca.pem",
+			},
+			wantOK:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{MQTTBrokerEnvVar, MQTTClientIDEnvVar, MQTTUsernameEnvVar, MQTTPasswordEnvVar,
+				MQTTTopicPrefixEnvVar, MQTTQoSEnvVar, MQTTCAFileEnvVar, MQTTCertFileEnvVar, MQTTKeyFileEnvVar, MQTTInsecureEnvVar} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			opts, ok, err := MQTTOptionsFromEnv("abc123", "web 01")
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !ok || err != nil {
+				return
+			}
+			if opts.ClientID != tt.wantID {
+				t.Errorf("ClientID = %q, want %q", opts.ClientID, tt.wantID)
+			}
+			if opts.TopicPrefix != tt.wantPrefix {
+				t.Errorf("TopicPrefix = %q, want %q", opts.TopicPrefix, tt.wantPrefix)
+			}
+			if opts.QoS != tt.wantQoS {
+				t.Errorf("QoS = %d, want %d", opts.QoS, tt.wantQoS)
+			}
+			if (opts.TLS != nil) != tt.wantTLS {
+				t.Errorf("TLS = %v, want set %v", opts.TLS, tt.wantTLS)
+			}
+			if tt.wantTLS && !opts.TLS.InsecureSkipVerify {
+				t.Error("InsecureSkipVerify not set")
+			}
+		})
+	}
+}
+
+func TestTopicSegment(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"open_ports", "open_ports"},
+		{"Disk Usage", "Disk_Usage"},
+		{"a/b", "a_b"},
+		{"cpu+mem", "cpu_mem"},
+		{"#1", "_1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := topicSegment(tt.in); got != tt.want {
+			t.Errorf("topicSegment(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTTopic(t *testing.T) {
+	m := &MQTT{prefix: "monitor-monkey/abc123"}
+	tests := []struct {
+		rec  *payload.Record
+		want string
+	}{
+		{payload.NewMesureRecord(&payload.Mesure{}), "monitor-monkey/abc123/heartbeat"},
+		{payload.NewEventRecord("abc123", "open_ports", nil), "monitor-monkey/abc123/events/open_ports"},
+		{payload.NewCustomAlertRecord("abc123", "Disk Usage", 1), "monitor-monkey/abc123/custom_alerts/Disk_Usage"},
+		{payload.NewProcessesRecord(nil, nil), "monitor-monkey/abc123/processes"},
+	}
+	for _, tt := range tests {
+		if got := m.topic(tt.rec); got != tt.want {
+			t.Errorf("topic(%s) = %q, want %q", tt.rec.Name(), got, tt.want)
+		}
+	}
+}
+
+// mqttMessage is a message a test subscriber received
+type mqttMessage struct {
+	topic    string
+	payload  string
+	qos      byte
+	retained bool
+}
+
+// subscribeMQTT connects a client to broker subscribed to topic and returns the messages it receives
+func subscribeMQTT(t *testing.T, broker, topic string) <-chan mqttMessage {
+	t.Helper()
+	messages := make(chan mqttMessage, 100)
+	client := mqtt.NewClient(mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("monitor-monkey-test-sub-%d", time.Now().UnixNano())).
+		SetCleanSession(true))
+	if token := client.Connect(); !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		t.Fatalf("subscriber failed to connect: %v", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(250) })
+
+	token := client.Subscribe(topic, 2, func(_ mqtt.Client, msg mqtt.Message) {
+		messages <- mqttMessage{topic: msg.Topic(), payload: string(msg.Payload()), qos: msg.Qos(), retained: msg.Retained()}
+	})
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to subscribe to %s: %v", topic, token.Error())
+	}
+	return messages
+}
+
+// waitMQTT returns the next message on topic, skipping others
+func waitMQTT(t *testing.T, messages <-chan mqttMessage, topic string) mqttMessage {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg := <-messages:
+			if msg.topic == topic {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no message on %s", topic)
+		}
+	}
+}
+
+// waitConnected waits for the sink to connect to its broker
+func waitConnected(t *testing.T, m *MQTT) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !m.client.IsConnectionOpen() {
+		if time.Now().After(deadline) {
+			t.Fatal("sink didn't connect to the broker")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// dropProxy forwards connections to a broker until drop cuts them without an MQTT DISCONNECT
+type dropProxy struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	conns    []net.Conn
+}
+
+func newDropProxy(t *testing.T, target string) *dropProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &dropProxy{listener: listener}
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			broker, err := net.Dial("tcp", target)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			p.mutex.Lock()
+			p.conns = append(p.conns, client, broker)
+			p.mutex.Unlock()
+			go func() { io.Copy(broker, client); broker.Close() }()
+			go func() { io.Copy(client, broker); client.Close() }()
+		}
+	}()
+	t.Cleanup(p.drop)
+	return p
+}
+
+// drop stops accepting and closes every connection
+func (p *dropProxy) drop() {
+	p.listener.Close()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+// TestMQTTBroker runs the sink against a real broker, set MONKEY_MQTT_TEST_BROKER to
+// a tcp:// broker such as a local mosquitto to run it
+func TestMQTTBroker(t *testing.T) {
+	broker := os.Getenv(mqttTestBrokerEnvVar)
+	if broker == "" {
+		t.Skip(mqttTestBrokerEnvVar + " not set")
+	}
+	brokerURL, err := url.Parse(broker)
+	if err != nil || brokerURL.Scheme != "tcp" {
+		t.Fatalf("invalid %s %q, want tcp://host:port", mqttTestBrokerEnvVar, broker)
+	}
+	conn, err := net.DialTimeout("tcp", brokerURL.Host, 2*time.Second)
+	if err != nil {
+		t.Skipf("no broker at %s: %v", broker, err)
+	}
+	conn.Close()
+
+	prefix := fmt.Sprintf("monitor-monkey-test/%d", time.Now().UnixNano())
+	statusTopic := prefix + "/status"
+	messages := subscribeMQTT(t, broker, prefix+"/#")
+	t.Cleanup(func() {
+		// clear the retained status
+		client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID(prefix + "-cleanup"))
+		if token := client.Connect(); token.WaitTimeout(10*time.Second) && token.Error() == nil {
+			client.Publish(statusTopic, 1, true, "").WaitTimeout(10 * time.Second)
+			client.Disconnect(250)
+		}
+	})
+
+	// the sink connects through a proxy so the test can cut the connection like a network failure would
+	proxy := newDropProxy(t, brokerURL.Host)
+	m := NewMQTT(MQTTOptions{
+		Broker:      "tcp://" + proxy.listener.Addr().String(),
+		ClientID:    prefix + "-sink",
+		TopicPrefix: prefix,
+		QoS:         1,
+	})
+	defer m.Close()
+	waitConnected(t, m)
+
+	if msg := waitMQTT(t, messages, statusTopic); msg.payload != mqttOnline {
+		t.Fatalf("status = %q, want %q", msg.payload, mqttOnline)
+	}
+	// a subscriber arriving later gets the retained status straight away
+	if msg := waitMQTT(t, subscribeMQTT(t, broker, statusTopic), statusTopic); msg.payload != mqttOnline || !msg.retained {
+		t.Errorf("late subscriber got status %q retained %v, want %q retained", msg.payload, msg.retained, mqttOnline)
+	}
+
+	for _, tt := range []struct {
+		rec   *payload.Record
+		topic string
+	}{
+		{payload.NewMesureRecord(&payload.Mesure{Heartbeat: 1700000000}), prefix + "/heartbeat"},
+		{payload.NewEventRecord("abc123", "open_ports", map[string]int{"tcp": 1}), prefix + "/events/open_ports"},
+		{payload.NewCustomAlertRecord("abc123", "Disk Usage", 42), prefix + "/custom_alerts/Disk_Usage"},
+	} {
+		if err := m.Send(tt.rec); err != nil {
+			t.Fatalf("Send(%s): %v", tt.rec.Name(), err)
+		}
+		msg := waitMQTT(t, messages, tt.topic)
+		if msg.qos != 1 || msg.retained {
+			t.Errorf("%s arrived with QoS %d retained %v, want QoS 1 not retained", tt.topic, msg.qos, msg.retained)
+		}
+		if msg.payload == "" {
+			t.Errorf("%s arrived empty", tt.topic)
+		}
+	}
+
+	// the broker publishes the Last Will when the connection drops without a DISCONNECT
+	proxy.drop()
+	if msg := waitMQTT(t, messages, statusTopic); msg.payload != mqttOffline {
+		t.Errorf("status after the connection dropped = %q, want %q", msg.payload, mqttOffline)
+	}
+	if msg := waitMQTT(t, subscribeMQTT(t, broker, statusTopic), statusTopic); msg.payload != mqttOffline || !msg.retained {
+		t.Errorf("late subscriber got status %q retained %v, want %q retained", msg.payload, msg.retained, mqttOffline)
+	}
+}
@@ -0,0 +1,36 @@
+// printer.go
+// writes records as indented JSON instead of sending them, for --once and --dry-run
+
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"go_monitor/payload"
+	"io"
+)
+
+// Printer writes every record to w
+type Printer struct {
+	w io.Writer
+}
+
+// NewPrinter creates a sink writing to w, usually stdout
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{w: w}
+}
+
+// Name identifies the sink
+func (p *Printer) Name() string {
+	return "printer"
+}
+
+// Send writes the record with its kind and name
+func (p *Printer) Send(rec *payload.Record) error {
+	jsonBytes, err := json.MarshalIndent(newEnvelope(rec), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", jsonBytes)
+	return err
+}
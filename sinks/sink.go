@@ -0,0 +1,92 @@
+// sink.go
+// destinations the agent can send its data to
+
+package sinks
+
+import (
+	"fmt"
+	"go_monitor/logger"
+	"go_monitor/payload"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var log = logger.For("sinks")
+
+// DefaultBufferSize is how many records a sink can fall behind before the oldest are dropped
+const DefaultBufferSize = 100
+
+// Sink receives every record the agent produces that passes its filter.
+// Sinks should ignore kinds of record they have no use for.
+type Sink interface {
+	Name() string
+	Send(rec *payload.Record) error
+}
+
+// Closer is implemented by sinks that need to flush or disconnect on shutdown
+type Closer interface {
+	Close() error
+}
+
+// Uplink is implemented by sinks that may carry heartbeats to the server.
+// Heartbeats that an uplink fails to deliver are counted as dropped samples.
+type Uplink interface {
+	IsUplink() bool
+}
+
+// envelope is how the local sinks write a record, with its kind and name alongside the payload
+type envelope struct {
+	Time    time.Time   `json:"time"`
+	Kind    string      `json:"kind"`
+	Name    string      `json:"name"`
+	Payload interface{} `json:"payload"`
+}
+
+func newEnvelope(rec *payload.Record) envelope {
+	return envelope{
+		Time:    rec.Time,
+		Kind:    string(rec.Kind),
+		Name:    rec.Name(),
+		Payload: rec.Payload(),
+	}
+}
+
+// Options controls how records reach a single sink
+type Options struct {
+	BufferSize int
+	Filter     Filter
+}
+
+// OptionsFromEnv reads MONKEY_SINK_<NAME>_BUFFER, _INCLUDE and _EXCLUDE for a sink
+func OptionsFromEnv(name string) (Options, error) {
+	prefix := "MONKEY_SINK_" + strings.ToUpper(name) + "_"
+	opts := Options{BufferSize: DefaultBufferSize}
+
+	if env := os.Getenv(prefix + "BUFFER"); env != "" {
+		size, err := strconv.Atoi(env)
+		if err != nil || size < 1 {
+			return opts, fmt.Errorf("invalid %sBUFFER %q", prefix, env)
+		}
+		opts.BufferSize = size
+	}
+
+	filter, err := NewFilter(splitList(os.Getenv(prefix+"INCLUDE")), splitList(os.Getenv(prefix+"EXCLUDE")))
+	if err != nil {
+		return opts, fmt.Errorf("invalid filter for sink %s: %w", name, err)
+	}
+	opts.Filter = filter
+	return opts, nil
+}
+
+// splitList splits a comma separated env var, dropping empty entries
+func splitList(raw string) []string {
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,284 @@
+// status.go
+// keeps track of what the running agent is doing so it can be reported locally
+
+package status
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// MaxConsecutiveFailures is how many failed sends in a row make the agent not ready
+const MaxConsecutiveFailures = 3
+
+// CollectorStatus holds the outcome of the most recent runs of a single collector
+type CollectorStatus struct {
+	LastRun       time.Time `json:"last_run"`
+	DurationMs    float64   `json:"duration_ms"`
+	Runs          uint64    `json:"runs"`
+	Errors        uint64    `json:"errors"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+}
+
+// SinkStatus holds delivery statistics for a single output sink
+type SinkStatus struct {
+	Name          string    `json:"name"`
+	QueueDepth    int       `json:"queue_depth"`
+	Sent          uint64    `json:"sent"`
+	Failed        uint64    `json:"failed"`
+	Dropped       uint64    `json:"dropped"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time"`
+}
+
+// Status is a point in time copy of the agent state
+type Status struct {
+	Version             string                     `json:"version"`
+	RunID               string                     `json:"run_id"`
+	Sequence            uint64                     `json:"sequence"`
+	DroppedSamples      uint64                     `json:"dropped_samples"`
+	Started             time.Time                  `json:"started"`
+	UptimeSeconds       int64                      `json:"uptime_seconds"`
+	LastCycle           time.Time                  `json:"last_cycle"`
+	LastSuccess         time.Time                  `json:"last_success"`
+	LastFailure         time.Time                  `json:"last_failure"`
+	LastSendError       string                     `json:"last_send_error,omitempty"`
+	ConsecutiveFailures int                        `json:"consecutive_failures"`
+	SendErrors          uint64                     `json:"send_errors"`
+	LastSendLatencyMs   float64                    `json:"last_send_latency_ms"`
+	LastCycleMs         float64                    `json:"last_cycle_ms"`
+	QueueDepth          int                        `json:"queue_depth"`
+	ConfigVersion       string                     `json:"config_version"`
+	Paused              bool                       `json:"paused"`
+	Collectors          map[string]CollectorStatus `json:"collectors"`
+	Sinks               []SinkStatus               `json:"sinks"`
+}
+
+// Agent state storage with mutex for thread safety
+var (
+	stateMutex sync.Mutex
+
+	version             string
+	started             = time.Now()
+	runID               = newRunID()
+	sequence            uint64
+	droppedSamples      uint64
+	lastCycle           time.Time
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	lastSendError       string
+	consecutiveFailures int
+	sendErrors          uint64
+	lastSendLatency     time.Duration
+	lastCycleDuration   time.Duration
+	configVersion       string
+	paused              bool
+	noUplink            bool
+	collectors          = make(map[string]*CollectorStatus)
+	sinkReporter        func() []SinkStatus
+)
+
+// SetVersion records the running agent version
+func SetVersion(v string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	version = v
+}
+
+// newRunID returns a random ID for this run of the agent
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RunID identifies this run of the agent, it changes every time the agent starts
+func RunID() string {
+	return runID
+}
+
+// NextSequence numbers a heartbeat, counting from 1 each run
+func NextSequence() uint64 {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	sequence++
+	return sequence
+}
+
+// RecordDroppedSample counts a heartbeat that was collected but never reached the server
+func RecordDroppedSample() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	droppedSamples++
+}
+
+// DroppedSamples returns how many heartbeats this run never reached the server
+func DroppedSamples() uint64 {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return droppedSamples
+}
+
+// SetConfigVersion records the version of the configuration currently applied
+func SetConfigVersion(v string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	configVersion = v
+}
+
+// RecordCycle marks the start of a main loop iteration
+func RecordCycle() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	lastCycle = time.Now()
+}
+
+// RecordCycleDuration records how long the latest collection cycle took
+func RecordCycleDuration(d time.Duration) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	lastCycleDuration = d
+}
+
+// RecordSendLatency records how long the latest update took to send
+func RecordSendLatency(d time.Duration) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	lastSendLatency = d
+}
+
+// RecordSend records the outcome of sending an update to the API
+func RecordSend(err error) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if err != nil {
+		lastFailure = time.Now()
+		lastSendError = err.Error()
+		consecutiveFailures++
+		sendErrors++
+		return
+	}
+	lastSuccess = time.Now()
+	consecutiveFailures = 0
+}
+
+// SetPaused records whether sending has been paused from the control socket
+func SetPaused(p bool) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	paused = p
+}
+
+// SetUplink records whether any sink carries heartbeats to the server and reports sends
+func SetUplink(configured bool) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	noUplink = !configured
+}
+
+// SetSinkReporter registers the function reporting on output sinks
+func SetSinkReporter(reporter func() []SinkStatus) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	sinkReporter = reporter
+}
+
+// TimeCollector runs a collector, recording how long it took and whether it failed
+func TimeCollector(name string, collect func() error) error {
+	start := time.Now()
+	err := collect()
+	duration := time.Since(start)
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	c, ok := collectors[name]
+	if !ok {
+		c = &CollectorStatus{}
+		collectors[name] = c
+	}
+	c.LastRun = start
+	c.DurationMs = float64(duration.Microseconds()) / 1000
+	c.Runs++
+	if err != nil {
+		c.Errors++
+		c.LastError = err.Error()
+		c.LastErrorTime = start
+	}
+	return err
+}
+
+// Snapshot returns a copy of the current agent state
+func Snapshot() Status {
+	stateMutex.Lock()
+	reporter := sinkReporter
+	stateMutex.Unlock()
+
+	// Sinks have their own locks, don't hold ours while asking them
+	var sinks []SinkStatus
+	queueDepth := 0
+	if reporter != nil {
+		sinks = reporter()
+		for _, sink := range sinks {
+			queueDepth += sink.QueueDepth
+		}
+	}
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	s := Status{
+		Version:             version,
+		RunID:               runID,
+		Sequence:            sequence,
+		DroppedSamples:      droppedSamples,
+		Started:             started,
+		UptimeSeconds:       int64(time.Since(started).Seconds()),
+		LastCycle:           lastCycle,
+		LastSuccess:         lastSuccess,
+		LastFailure:         lastFailure,
+		LastSendError:       lastSendError,
+		ConsecutiveFailures: consecutiveFailures,
+		SendErrors:          sendErrors,
+		LastSendLatencyMs:   float64(lastSendLatency.Microseconds()) / 1000,
+		LastCycleMs:         float64(lastCycleDuration.Microseconds()) / 1000,
+		QueueDepth:          queueDepth,
+		ConfigVersion:       configVersion,
+		Paused:              paused,
+		Collectors:          make(map[string]CollectorStatus, len(collectors)),
+		Sinks:               sinks,
+	}
+	for name, c := range collectors {
+		s.Collectors[name] = *c
+	}
+	return s
+}
+
+// Healthy reports whether the main loop is still cycling.
+// Before the first cycle the agent is given startupGrace to fetch its configuration.
+func Healthy(maxCycleAge, startupGrace time.Duration) bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if lastCycle.IsZero() {
+		return time.Since(started) < startupGrace
+	}
+	return time.Since(lastCycle) < maxCycleAge
+}
+
+// Ready reports whether the agent has delivered data and isn't currently failing to.
+// Without an uplink no sink reports its sends, e.g. Prometheus or the archive only,
+// so the agent is ready once it has handed a cycle to the sinks.
+func Ready() bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	if noUplink {
+		return !lastCycle.IsZero()
+	}
+	return !lastSuccess.IsZero() && consecutiveFailures < MaxConsecutiveFailures
+}